@@ -6,9 +6,21 @@ require (
 	github.com/Knetic/govaluate v3.0.1-0.20171022003610-9aa49832a739+incompatible
 	github.com/coredns/caddy v1.1.0
 	github.com/coredns/coredns v1.8.4
+	github.com/google/cel-go v0.7.3
+	github.com/google/uuid v1.2.0
 	github.com/infobloxopen/go-trees v0.0.0-20200715205103-96a057b8dfb9
 	github.com/infobloxopen/themis v0.0.5
-	github.com/miekg/dns v1.1.42
-	github.com/prometheus/client_golang v1.10.0
+	github.com/miekg/dns v1.1.43
+	github.com/open-policy-agent/opa v0.39.0
+	github.com/oschwald/maxminddb-golang v1.8.0
+	github.com/prometheus/client_golang v1.12.1
 	github.com/prometheus/client_model v0.2.0
+	github.com/tetratelabs/wazero v1.2.1
+	go.starlark.net v0.0.0-20231101134539-556fd59b42f6
+	google.golang.org/genproto v0.0.0-20211208223120-3a66f561d7aa
+	// themis v0.0.5 uses grpc.WithBalancerName, that is removed from grpc v1.46.0
+	google.golang.org/grpc v1.45.0
+	google.golang.org/protobuf v1.28.0
 )
+
+require k8s.io/klog/v2 v2.30.0 // indirect
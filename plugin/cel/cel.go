@@ -0,0 +1,245 @@
+// Package cel implements a policy engine for the firewall plugin where the rules are expressions
+// written in the Common Expression Language (CEL).
+package cel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+
+	gocel "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/google/cel-go/interpreter/functions"
+	"github.com/miekg/dns"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+)
+
+// cel is a policy engine plugin for the firewall plugin that evaluates rules written as CEL expressions
+type cel struct {
+	engines map[string]*engine
+	next    plugin.Handler
+}
+
+// engine builds and evaluates rules written as CEL expressions
+type engine struct {
+	env     *gocel.Env
+	mapping *rqdata.Mapping // store this so we dont have to rebuild it for every request
+}
+
+// rule is a compiled CEL expression and the action to return when it evaluates to true
+type rule struct {
+	action     int
+	expression string
+	program    gocel.Program
+}
+
+// variable is a typed variable of the expressions, and the field of the request it is extracted from
+type variable struct {
+	name  string
+	field string
+	kind  *exprpb.Type
+}
+
+var variables = []variable{
+	{"request.type", "type", decls.String},
+	{"request.name", "name", decls.String},
+	{"request.class", "class", decls.String},
+	{"request.proto", "proto", decls.String},
+	{"request.size", "size", decls.Int},
+	{"request.client_ip", "client_ip", decls.String},
+	{"request.port", "port", decls.Int},
+	{"request.server_ip", "server_ip", decls.String},
+	{"request.server_port", "server_port", decls.Int},
+	{"request.id", ">id", decls.Int},
+	{"request.opcode", ">opcode", decls.Int},
+	{"request.do", ">do", decls.Bool},
+	{"request.bufsize", ">bufsize", decls.Int},
+	{"response.rcode", "rcode", decls.String},
+	{"response.size", "rsize", decls.Int},
+	{"response.flags", ">rflags", decls.NewListType(decls.String)},
+	{"response.ip", "response_ip", decls.String},
+}
+
+var variablesByName = func() map[string]variable {
+	m := make(map[string]variable, len(variables))
+	for _, v := range variables {
+		m[v.name] = v
+	}
+	return m
+}()
+
+func newCel() *cel {
+	return &cel{engines: make(map[string]*engine)}
+}
+
+func newEngine(m *rqdata.Mapping) (*engine, error) {
+	d := []*exprpb.Decl{
+		decls.NewVar("metadata", decls.NewMapType(decls.String, decls.String)),
+		decls.NewFunction("incidr",
+			decls.NewOverload("incidr_string_string", []*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+	}
+	for _, v := range variables {
+		d = append(d, decls.NewVar(v.name, v.kind))
+	}
+	env, err := gocel.NewEnv(gocel.Declarations(d...))
+	if err != nil {
+		return nil, err
+	}
+	return &engine{env: env, mapping: m}, nil
+}
+
+// Name implements the Handler interface
+func (p *cel) Name() string { return "cel" }
+
+// ServeDNS implements the Handler interface
+func (p *cel) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	// do nothing
+	return plugin.NextOrFailure(p.Name(), p.next, ctx, w, r)
+}
+
+// Engine implements the policy.Engineer interface
+func (p *cel) Engine(name string) policy.Engine {
+	return p.engines[name]
+}
+
+// BuildQueryData implements the policy.Engine interface
+func (e *engine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
+	return &activation{ctx: ctx, extractor: rqdata.NewExtractor(state, e.mapping)}, nil
+}
+
+// BuildReplyData implements the policy.Engine interface
+func (e *engine) BuildReplyData(ctx context.Context, state request.Request, queryData interface{}) (interface{}, error) {
+	a := &activation{ctx: ctx, extractor: rqdata.NewExtractor(state, e.mapping)}
+	if q, ok := queryData.(*activation); ok {
+		a.metadata = q.metadata
+	}
+	return a, nil
+}
+
+// BuildRule implements the policy.Engine interface
+// - first param is one of the action to return
+// - second and following params are the CEL expression, that must evaluate to a boolean
+func (e *engine) BuildRule(args []string) (policy.Rule, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("expect an action and an expression, got %s", strings.Join(args, " "))
+	}
+	var kind = policy.TypeNone
+	for k, n := range policy.NameTypes {
+		if args[0] == n {
+			kind = k
+		}
+	}
+	if kind == policy.TypeNone {
+		return nil, fmt.Errorf("invalid keyword %s for a policy rule", args[0])
+	}
+
+	exp := strings.Join(args[1:], " ")
+	ast, iss := e.env.Compile(exp)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("cannot compile the expression '%s' : %s", exp, iss.Err())
+	}
+	if !proto.Equal(ast.ResultType(), decls.Bool) {
+		return nil, fmt.Errorf("the expression '%s' must evaluate to a bool, not %s", exp, gocel.FormatType(ast.ResultType()))
+	}
+	prg, err := e.env.Program(ast, gocel.Functions(&functions.Overload{
+		Operator: "incidr_string_string",
+		Binary:   incidr,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create a program for the expression '%s' : %s", exp, err)
+	}
+	return &rule{action: kind, expression: exp, program: prg}, nil
+}
+
+// Evaluate implements the policy.Rule interface
+func (r *rule) Evaluate(data interface{}) (int, error) {
+	a, ok := data.(*activation)
+	if !ok {
+		return policy.TypeRefuse, fmt.Errorf("evaluation of expression '%s' - data provided are of wrong type", r.expression)
+	}
+	v, _, err := r.program.Eval(a)
+	if err != nil {
+		return policy.TypeRefuse, fmt.Errorf("evaluation of expression '%s' return an error : %s", r.expression, err)
+	}
+	if v == types.True {
+		return r.action, nil
+	}
+	return policy.TypeNone, nil
+}
+
+// activation provides the variables of the expressions for one request, extracted only when they are used
+type activation struct {
+	ctx       context.Context
+	extractor *rqdata.Extractor
+	metadata  map[string]string
+}
+
+// ResolveName implements the interpreter.Activation interface
+func (a *activation) ResolveName(name string) (interface{}, bool) {
+	if name == "metadata" {
+		if a.metadata == nil {
+			a.metadata = make(map[string]string)
+			for label, f := range metadata.ValueFuncs(a.ctx) {
+				a.metadata[label] = f()
+			}
+		}
+		return a.metadata, true
+	}
+	v, ok := variablesByName[name]
+	if !ok {
+		return nil, false
+	}
+	tv, _ := a.extractor.TypedValue(v.field)
+	return toCelValue(tv, v.kind), true
+}
+
+// Parent implements the interpreter.Activation interface
+func (a *activation) Parent() interpreter.Activation {
+	return nil
+}
+
+// toCelValue convert a typed value of the request into the type declared for the variable.
+// Data that is not available is the zero value of that type
+func toCelValue(v interface{}, kind *exprpb.Type) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case net.IP:
+		return v.String()
+	case nil:
+		switch {
+		case proto.Equal(kind, decls.Int):
+			return int64(0)
+		case proto.Equal(kind, decls.Bool):
+			return false
+		case proto.Equal(kind, decls.String):
+			return ""
+		}
+		return []string{}
+	}
+	return v
+}
+
+// incidr returns true if the IP address is in the subnet
+func incidr(lhs ref.Val, rhs ref.Val) ref.Val {
+	ip := net.ParseIP(string(lhs.(types.String)))
+	if ip == nil {
+		return types.NewErr("first argument is not an IP address")
+	}
+	_, cidr, err := net.ParseCIDR(string(rhs.(types.String)))
+	if err != nil {
+		return types.NewErr(err.Error())
+	}
+	return types.Bool(cidr.Contains(ip))
+}
@@ -0,0 +1,139 @@
+package cel
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/response"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+
+	"github.com/miekg/dns"
+)
+
+func TestBuildRule(t *testing.T) {
+	tests := []struct {
+		rule       string
+		errorBuild bool
+	}{
+		{"allow true", false},
+		{"block request.name.endsWith('example.org.')", false},
+		{"refuse request.size > 512 && request.do", false},
+		{"drop 'aa' in response.flags", false},
+		{"allow metadata['kubernetes/namespace'] == 'default'", false},
+		{"allow incidr(request.client_ip, '10.0.0.0/8')", false},
+		{"allow", true},
+		{"unknown true", true},
+		{"allow request.size > '512'", true},
+		{"allow request.nme == 'example.org.'", true},
+		{"allow request.name", true},
+		{"allow request.name ==", true},
+	}
+	e, err := newEngine(rqdata.NewMapping(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range tests {
+		_, err := e.BuildRule(strings.Split(test.rule, " "))
+		if err != nil {
+			if !test.errorBuild {
+				t.Errorf("Test %d, rule : %s - unexpected error at build rule : %s", i, test.rule, err)
+			}
+			continue
+		}
+		if test.errorBuild {
+			t.Errorf("Test %d, rule : %s - no error at build rule, when one was expected", i, test.rule)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		rule     string
+		reply    bool
+		expected int
+	}{
+		{"allow true", false, policy.TypeAllow},
+		{"block request.name == 'example.org.'", false, policy.TypeBlock},
+		{"block request.name == 'example.com.'", false, policy.TypeNone},
+		{"refuse request.type == 'A' && request.size < 512", false, policy.TypeRefuse},
+		{"drop request.client_ip == '10.240.0.1'", false, policy.TypeDrop},
+		{"allow incidr(request.client_ip, '10.240.0.0/16')", false, policy.TypeAllow},
+		{"allow !request.do", false, policy.TypeAllow},
+		{"allow metadata['test/group'] == 'admin'", false, policy.TypeAllow},
+		{"allow 'test/missing' in metadata", false, policy.TypeNone},
+		{"allow response.rcode == '' && response.size == 0", false, policy.TypeAllow},
+		{"block response.ip == '1.2.3.4'", true, policy.TypeBlock},
+		{"block response.rcode == 'NOERROR' && 'qr' in response.flags", true, policy.TypeBlock},
+		{"allow metadata['test/group'] == 'admin'", true, policy.TypeAllow},
+	}
+
+	e, err := newEngine(rqdata.NewMapping(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := metadata.ContextWithMetadata(context.TODO())
+	metadata.SetValueFunc(ctx, "test/group", func() string { return "admin" })
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	state := request.Request{W: response.NewReader(&test.ResponseWriter{}), Req: r}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = []dns.RR{test.A("example.org.  5  IN  A  1.2.3.4")}
+	stateReply := request.Request{W: &response.Reader{Msg: m}, Req: r}
+
+	for i, tc := range tests {
+		rule, err := e.BuildRule(strings.Split(tc.rule, " "))
+		if err != nil {
+			t.Errorf("Test %d, rule : %s - unexpected error at build rule : %s", i, tc.rule, err)
+			continue
+		}
+		data, err := e.BuildQueryData(ctx, state)
+		if err != nil {
+			t.Errorf("Test %d, rule : %s - unexpected error at build query data : %s", i, tc.rule, err)
+			continue
+		}
+		if tc.reply {
+			data, err = e.BuildReplyData(ctx, stateReply, data)
+			if err != nil {
+				t.Errorf("Test %d, rule : %s - unexpected error at build reply data : %s", i, tc.rule, err)
+				continue
+			}
+		}
+		result, err := rule.Evaluate(data)
+		if err != nil {
+			t.Errorf("Test %d, rule : %s - unexpected error at evaluate : %s", i, tc.rule, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Test %d, rule : %s - expected %s, got %s", i, tc.rule, policy.NameTypes[tc.expected], policy.NameTypes[result])
+		}
+	}
+}
+
+func TestEvaluateError(t *testing.T) {
+	e, err := newEngine(rqdata.NewMapping(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := e.BuildRule([]string{"allow", "metadata['test/missing']", "==", "''"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	data, _ := e.BuildQueryData(context.TODO(), request.Request{W: &test.ResponseWriter{}, Req: r})
+	if _, err := rule.Evaluate(data); err == nil {
+		t.Errorf("expected an error at evaluation of a missing metadata")
+	}
+	if _, err := rule.Evaluate("invalid data"); err == nil {
+		t.Errorf("expected an error at evaluation of invalid data")
+	}
+}
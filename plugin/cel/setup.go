@@ -0,0 +1,51 @@
+package cel
+
+import (
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+)
+
+func init() {
+	caddy.RegisterPlugin("cel", caddy.Plugin{
+		ServerType: "dns",
+		Action:     setup,
+	})
+}
+
+func setup(c *caddy.Controller) error {
+	p, err := parse(c)
+	if err != nil {
+		return plugin.Error("cel", err)
+	}
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		p.next = next
+		return p
+	})
+	return nil
+}
+
+func parse(c *caddy.Controller) (*cel, error) {
+	p := newCel()
+	mapping := rqdata.NewMapping("")
+	for c.Next() {
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		name := args[0]
+		if _, ok := p.engines[name]; ok {
+			return nil, c.Errf("cel engine %s is already declared", name)
+		}
+		for c.NextBlock() {
+			return nil, c.Errf("unknown property '%s'", c.Val())
+		}
+		e, err := newEngine(mapping)
+		if err != nil {
+			return nil, err
+		}
+		p.engines[name] = e
+	}
+	return p, nil
+}
@@ -0,0 +1,50 @@
+package cel
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input     string
+		shouldErr bool
+		engines   []string
+	}{
+		{`cel`, true, nil},
+		{`cel one two`, true, nil},
+		{`cel myengine`, false, []string{"myengine"}},
+		{`cel myengine {
+		  }`, false, []string{"myengine"}},
+		{`cel myengine {
+		    unknown
+		  }`, true, nil},
+		{`cel myengine
+		  cel other`, false, []string{"myengine", "other"}},
+		{`cel myengine
+		  cel myengine`, true, nil},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		p, err := parse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but didn't get one for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but got one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if len(p.engines) != len(test.engines) {
+			t.Errorf("Test %d: expected %d engines, got %d", i, len(test.engines), len(p.engines))
+		}
+		for _, name := range test.engines {
+			if p.Engine(name) == nil {
+				t.Errorf("Test %d: expected engine %s to be declared", i, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package extauthz implements a policy engine for the firewall plugin that asks an external gRPC
+// authorization service for the decision, using the API defined in the pb package.
+package extauthz
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/extauthz/pb"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/response"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// extauthz is a policy engine plugin for the firewall plugin that asks external authorization services
+type extauthz struct {
+	engines map[string]*engine
+	next    plugin.Handler
+}
+
+// engine sends the DNS requests and replies to an external authorization service, and return its decision
+type engine struct {
+	name     string
+	endpoint string
+	mapping  *rqdata.Mapping   // store this so we dont have to rebuild it for every request
+	timeout  time.Duration     // deadline of each call to the service
+	size     int               // number of connections to the service
+	opts     []grpc.DialOption // options of the connections, that are opened at startup
+
+	conns   []*grpc.ClientConn
+	clients []pb.AuthorizationClient
+	next    uint32 // index of the connection of the next call, the calls are spread over the connections
+}
+
+// checkData is the request sent to the service for one DNS request or reply
+type checkData struct {
+	ctx context.Context
+	req *pb.CheckDNSRequest
+}
+
+// metadataPrefix is the prefix of the labels of the metadata returned by the service
+const metadataPrefix = "extauthz/"
+
+func newExtauthz() *extauthz {
+	return &extauthz{engines: make(map[string]*engine)}
+}
+
+func newEngine(name string, m *rqdata.Mapping) *engine {
+	return &engine{
+		name:    name,
+		mapping: m,
+		timeout: time.Second,
+		size:    1,
+	}
+}
+
+// Name implements the Handler interface
+func (p *extauthz) Name() string { return "extauthz" }
+
+// ServeDNS implements the Handler interface
+func (p *extauthz) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	// do nothing
+	return plugin.NextOrFailure(p.Name(), p.next, ctx, w, r)
+}
+
+// Engine implements the policy.Engineer interface
+func (p *extauthz) Engine(name string) policy.Engine {
+	return p.engines[name]
+}
+
+// BuildQueryData implements the policy.Engine interface
+func (e *engine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
+	req := &pb.CheckDNSRequest{
+		Phase:  pb.Phase_PHASE_QUERY,
+		Engine: e.name,
+		Id:     uint32(state.Req.Id),
+		Opcode: uint32(state.Req.Opcode),
+		Client: &pb.Client{
+			Ip:         state.IP(),
+			Port:       parsePort(state.Port()),
+			Protocol:   state.Proto(),
+			ServerIp:   state.LocalIP(),
+			ServerPort: parsePort(state.LocalPort()),
+		},
+		Metadata: make(map[string]string),
+	}
+	if len(state.Req.Question) > 0 {
+		q := state.Req.Question[0]
+		req.Question = &pb.Question{Name: q.Name, Type: uint32(q.Qtype), Class: uint32(q.Qclass)}
+	}
+	if opt := state.Req.IsEdns0(); opt != nil {
+		req.Edns = &pb.EDNS{UdpSize: uint32(opt.UDPSize()), Do: opt.Do(), Options: options(opt)}
+	}
+	for label, f := range metadata.ValueFuncs(ctx) {
+		req.Metadata[label] = f()
+	}
+	return &checkData{ctx: ctx, req: req}, nil
+}
+
+// BuildReplyData implements the policy.Engine interface
+func (e *engine) BuildReplyData(ctx context.Context, state request.Request, queryData interface{}) (interface{}, error) {
+	q, ok := queryData.(*checkData)
+	if !ok {
+		d, err := e.BuildQueryData(ctx, state)
+		if err != nil {
+			return nil, err
+		}
+		q = d.(*checkData)
+	}
+	// the query data can be shared with other rules, it is not modified
+	req := proto.Clone(q.req).(*pb.CheckDNSRequest)
+	req.Phase = pb.Phase_PHASE_RESPONSE
+	if rr, ok := state.W.(*response.Reader); ok && rr.Msg != nil {
+		tv, _ := rqdata.NewExtractor(state, e.mapping).TypedValue(">rflags")
+		flags, _ := tv.([]string)
+		req.Response = &pb.Response{
+			Rcode:      uint32(rr.Msg.Rcode),
+			Flags:      flags,
+			Answer:     records(rr.Msg.Answer),
+			Authority:  records(rr.Msg.Ns),
+			Additional: records(rr.Msg.Extra),
+		}
+	}
+	return &checkData{ctx: ctx, req: req}, nil
+}
+
+// BuildRule implements the policy.Engine interface
+func (e *engine) BuildRule(args []string) (policy.Rule, error) { return e, nil }
+
+// Evaluate implements the policy.Rule interface
+func (e *engine) Evaluate(data interface{}) (int, error) {
+	d, err := e.Decide(data)
+	if err != nil {
+		return policy.TypeRefuse, err
+	}
+	return d.Action, nil
+}
+
+// Decide implements the policy.Decider interface
+func (e *engine) Decide(data interface{}) (*policy.Decision, error) {
+	in, ok := data.(*checkData)
+	if !ok {
+		return nil, fmt.Errorf("evaluation of extauthz engine %s - data provided are of wrong type", e.name)
+	}
+	ctx, cancel := context.WithTimeout(in.ctx, e.timeout)
+	defer cancel()
+	resp, err := e.client().CheckDNS(ctx, in.req)
+	if err != nil {
+		return nil, fmt.Errorf("extauthz engine %s cannot check the request : %s", e.name, err)
+	}
+
+	d, err := toDecision(resp)
+	if err == nil && d.Rcode != nil && *d.Rcode > 0xf && in.req.Edns == nil {
+		// the upper bits of an extended rcode are sent in the OPT record
+		err = fmt.Errorf("extended rcode %d for a request without EDNS0", *d.Rcode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("extauthz engine %s received an invalid decision : %s", e.name, err)
+	}
+	for label, value := range resp.Metadata {
+		value := value
+		metadata.SetValueFunc(in.ctx, metadataPrefix+label, func() string { return value })
+	}
+	return d, nil
+}
+
+// client return the client of the connection to use for the next call
+func (e *engine) client() pb.AuthorizationClient {
+	i := atomic.AddUint32(&e.next, 1)
+	return e.clients[int(i)%len(e.clients)]
+}
+
+// connect opens the connections to the service. Connections are established in the background
+func (e *engine) connect() error {
+	for i := 0; i < e.size; i++ {
+		conn, err := grpc.Dial(e.endpoint, e.opts...)
+		if err != nil {
+			e.close()
+			return err
+		}
+		e.conns = append(e.conns, conn)
+		e.clients = append(e.clients, pb.NewAuthorizationClient(conn))
+	}
+	return nil
+}
+
+// close closes the connections to the service
+func (e *engine) close() {
+	for _, conn := range e.conns {
+		conn.Close()
+	}
+	e.conns = nil
+	e.clients = nil
+}
+
+// toDecision convert the response of the service into a Decision of the firewall
+func toDecision(resp *pb.CheckDNSResponse) (*policy.Decision, error) {
+	d := &policy.Decision{Action: int(resp.Action)}
+	if d.Action >= policy.TypeCount {
+		return nil, fmt.Errorf("unknown action: %d", resp.Action)
+	}
+	if resp.Rcode != nil {
+		rcode := int(*resp.Rcode)
+		if rcode > 0xfff {
+			return nil, fmt.Errorf("invalid rcode: %d", rcode)
+		}
+		d.Rcode = &rcode
+	}
+	for _, s := range resp.Answer {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid answer record '%s' : %s", s, err)
+		}
+		if rr == nil {
+			return nil, fmt.Errorf("empty answer record")
+		}
+		d.Answer = append(d.Answer, rr)
+	}
+	if ee := resp.ExtendedError; ee != nil {
+		if ee.InfoCode > 0xffff {
+			return nil, fmt.Errorf("invalid extended error code: %d", ee.InfoCode)
+		}
+		d.ExtendedError = &dns.EDNS0_EDE{InfoCode: uint16(ee.InfoCode), ExtraText: ee.ExtraText}
+	}
+	return d, nil
+}
+
+// records convert the records of a section of a response. The data is in presentation format
+func records(rrs []dns.RR) []*pb.ResourceRecord {
+	var l []*pb.ResourceRecord
+	for _, r := range rqdata.Records(rrs) {
+		l = append(l, &pb.ResourceRecord{
+			Name:  r.Name,
+			Type:  uint32(r.Type),
+			Class: uint32(r.Class),
+			Ttl:   r.TTL,
+			Rdata: r.Rdata,
+		})
+	}
+	return l
+}
+
+// options return the EDNS0 options of the OPT record with the wire format of their data
+func options(opt *dns.OPT) []*pb.EDNSOption {
+	b := make([]byte, dns.Len(opt))
+	n, err := dns.PackRR(opt, b, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	var l []*pb.EDNSOption
+	// the options follow the header of the record: root name, type, class, ttl and rdlength
+	for off := 11; off+4 <= n; {
+		code := binary.BigEndian.Uint16(b[off:])
+		size := int(binary.BigEndian.Uint16(b[off+2:]))
+		off += 4
+		if off+size > n {
+			break
+		}
+		l = append(l, &pb.EDNSOption{Code: uint32(code), Data: append([]byte(nil), b[off:off+size]...)})
+		off += size
+	}
+	return l
+}
+
+// parsePort return the port as an integer, or 0 if it is not a valid port
+func parsePort(s string) uint32 {
+	p, _ := rqdata.ParsePort(s)
+	return uint32(p)
+}
@@ -0,0 +1,224 @@
+package extauthz
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/extauthz/pb"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/response"
+
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+// decide is the policy of the test service, based on the name of the question
+func decide(ctx context.Context, req *pb.CheckDNSRequest) (*pb.CheckDNSResponse, error) {
+	switch req.Question.GetName() {
+	case "allow.example.":
+		return &pb.CheckDNSResponse{Action: pb.Action_ACTION_ALLOW}, nil
+	case "redirect.example.":
+		return &pb.CheckDNSResponse{
+			Action:        pb.Action_ACTION_BLOCK,
+			Answer:        []string{"redirect.example. 60 IN A 192.0.2.1"},
+			ExtendedError: &pb.ExtendedError{InfoCode: 15, ExtraText: "malware"},
+			Metadata:      map[string]string{"category": "malware"},
+		}, nil
+	case "servfail.example.":
+		return &pb.CheckDNSResponse{Action: pb.Action_ACTION_REFUSE, Rcode: proto.Uint32(dns.RcodeServerFailure)}, nil
+	case "badcookie.example.":
+		return &pb.CheckDNSResponse{Action: pb.Action_ACTION_REFUSE, Rcode: proto.Uint32(dns.RcodeBadCookie)}, nil
+	case "invalid.example.":
+		return &pb.CheckDNSResponse{Action: 9}, nil
+	case "badrr.example.":
+		return &pb.CheckDNSResponse{Action: pb.Action_ACTION_BLOCK, Answer: []string{"not a record"}}, nil
+	case "slow.example.":
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		return &pb.CheckDNSResponse{Action: pb.Action_ACTION_ALLOW}, nil
+	case "error.example.":
+		return nil, fmt.Errorf("test error")
+	}
+	if req.Phase == pb.Phase_PHASE_RESPONSE && len(req.Response.GetAnswer()) > 0 {
+		return &pb.CheckDNSResponse{Action: pb.Action_ACTION_DROP}, nil
+	}
+	return &pb.CheckDNSResponse{Action: pb.Action_ACTION_NONE}, nil
+}
+
+func newTestEngine(t *testing.T, s *testServer) *engine {
+	p, err := parse(caddy.NewTestController("dns", `extauthz myengine {
+		endpoint `+startServer(t, s)+`
+		timeout 100ms
+		connections 2
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := p.engines["myengine"]
+	if err := e.connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.close)
+	return e
+}
+
+func TestDecide(t *testing.T) {
+	s := &testServer{decide: decide}
+	e := newTestEngine(t, s)
+
+	tests := []struct {
+		name      string
+		edns      bool
+		expected  int
+		rcode     int
+		answer    int
+		ede       bool
+		shouldErr bool
+	}{
+		{"allow.example.", false, policy.TypeAllow, -1, 0, false, false},
+		{"redirect.example.", false, policy.TypeBlock, -1, 1, true, false},
+		{"servfail.example.", false, policy.TypeRefuse, dns.RcodeServerFailure, 0, false, false},
+		{"other.example.", false, policy.TypeNone, -1, 0, false, false},
+		{"invalid.example.", false, 0, 0, 0, false, true},
+		{"badrr.example.", false, 0, 0, 0, false, true},
+		{"slow.example.", false, 0, 0, 0, false, true},
+		{"error.example.", false, 0, 0, 0, false, true},
+		{"badcookie.example.", true, policy.TypeRefuse, dns.RcodeBadCookie, 0, false, false},
+		{"badcookie.example.", false, 0, 0, 0, false, true},
+	}
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tc.name, dns.TypeA)
+		if tc.edns {
+			r.SetEdns0(4096, false)
+		}
+		ctx := metadata.ContextWithMetadata(context.TODO())
+
+		data, err := e.BuildQueryData(ctx, request.Request{W: &test.ResponseWriter{}, Req: r})
+		if err != nil {
+			t.Fatalf("Test %d: unexpected error at build query data : %s", i, err)
+		}
+		d, err := e.Decide(data)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error at decide", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error at decide : %s", i, err)
+			continue
+		}
+		if d.Action != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[tc.expected], policy.NameTypes[d.Action])
+		}
+		if (d.Rcode == nil && tc.rcode >= 0) || (d.Rcode != nil && *d.Rcode != tc.rcode) {
+			t.Errorf("Test %d: expected rcode %d, got %v", i, tc.rcode, d.Rcode)
+		}
+		if len(d.Answer) != tc.answer {
+			t.Errorf("Test %d: expected %d answer records, got %d", i, tc.answer, len(d.Answer))
+		}
+		if (d.ExtendedError != nil) != tc.ede {
+			t.Errorf("Test %d: expected extended error : %v, got %v", i, tc.ede, d.ExtendedError)
+		}
+		if tc.ede {
+			if d.ExtendedError.InfoCode != 15 || d.ExtendedError.ExtraText != "malware" {
+				t.Errorf("Test %d: unexpected extended error %v", i, d.ExtendedError)
+			}
+			if f := metadata.ValueFunc(ctx, "extauthz/category"); f == nil || f() != "malware" {
+				t.Errorf("Test %d: expected metadata extauthz/category to be set", i)
+			}
+		}
+	}
+}
+
+func TestCheckDNSRequest(t *testing.T) {
+	s := &testServer{decide: decide}
+	e := newTestEngine(t, s)
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeAAAA)
+	r.SetEdns0(1232, true)
+	opt := r.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()})
+
+	ctx := metadata.ContextWithMetadata(context.TODO())
+	metadata.SetValueFunc(ctx, "test/group", func() string { return "admin" })
+	qdata, err := e.BuildQueryData(ctx, request.Request{W: &test.ResponseWriter{}, Req: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Decide(qdata); err != nil {
+		t.Fatal(err)
+	}
+	req := s.last()
+	if req.Phase != pb.Phase_PHASE_QUERY || req.Engine != "myengine" || req.Response != nil {
+		t.Errorf("unexpected request phase, engine or response %v", req)
+	}
+	if q := req.Question; q.GetName() != "example.org." || q.GetType() != uint32(dns.TypeAAAA) || q.GetClass() != uint32(dns.ClassINET) {
+		t.Errorf("unexpected question %v", q)
+	}
+	if c := req.Client; c.GetIp() != "10.240.0.1" || c.GetPort() != 40212 || c.GetProtocol() != "udp" || c.GetServerIp() != "127.0.0.1" || c.GetServerPort() != 53 {
+		t.Errorf("unexpected client %v", c)
+	}
+	if ed := req.Edns; ed.GetUdpSize() != 1232 || !ed.GetDo() || len(ed.GetOptions()) != 1 || ed.Options[0].Code != uint32(dns.EDNS0SUBNET) {
+		t.Errorf("unexpected edns %v", ed)
+	} else if data := ed.Options[0].Data; len(data) != 7 || data[4] != 192 || data[6] != 2 {
+		// family, source and scope netmasks, and the 3 bytes of the address
+		t.Errorf("unexpected subnet option data %v", data)
+	}
+	if req.Metadata["test/group"] != "admin" {
+		t.Errorf("unexpected metadata %v", req.Metadata)
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	m.Answer = []dns.RR{
+		test.CNAME("example.org. 300 IN CNAME www.example.org."),
+		test.AAAA("www.example.org. 300 IN AAAA 2001:db8::1"),
+	}
+	m.Ns = []dns.RR{test.NS("example.org. 300 IN NS ns.example.org.")}
+	m.SetEdns0(1232, true)
+	rdata, err := e.BuildReplyData(ctx, request.Request{W: &response.Reader{Msg: m}, Req: m}, qdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := e.Decide(rdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Action != policy.TypeDrop {
+		t.Errorf("expected drop for a response with answers, got %s", policy.NameTypes[d.Action])
+	}
+	req = s.last()
+	if req.Phase != pb.Phase_PHASE_RESPONSE || req.Question.GetName() != "example.org." {
+		t.Errorf("unexpected request phase or question %v", req)
+	}
+	resp := req.Response
+	if resp.GetRcode() != uint32(dns.RcodeSuccess) || len(resp.GetFlags()) != 3 || resp.Flags[1] != "aa" {
+		t.Errorf("unexpected response rcode or flags %v", resp)
+	}
+	if len(resp.GetAnswer()) != 2 || resp.Answer[0].Rdata != "www.example.org." || resp.Answer[1].Rdata != "2001:db8::1" ||
+		resp.Answer[1].Type != uint32(dns.TypeAAAA) || resp.Answer[1].Ttl != 300 {
+		t.Errorf("unexpected answer records %v", resp.GetAnswer())
+	}
+	// the OPT record of the response is not sent
+	if len(resp.GetAuthority()) != 1 || len(resp.GetAdditional()) != 0 {
+		t.Errorf("unexpected authority or additional records %v", resp)
+	}
+	// the query data is not modified by the response
+	if qdata.(*checkData).req.Phase != pb.Phase_PHASE_QUERY {
+		t.Errorf("expected the query data to be unchanged")
+	}
+}
@@ -0,0 +1,7 @@
+// Package pb is the protobuf API of the extauthz plugin, implemented by the external authorization services.
+//
+// The gRPC code is generated with protoc-gen-go-grpc v1.2.0, that supports the grpc v1.45 the module is pinned
+// to for themis. Do not regenerate it with protoc-gen-go-grpc v1.4.0 or later, their code requires grpc v1.62.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative extauthz.proto
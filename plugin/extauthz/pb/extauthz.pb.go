@@ -0,0 +1,1153 @@
+// The API called by the extauthz plugin of CoreDNS to authorize DNS queries and responses.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.0
+// 	protoc        v3.21.12
+// source: extauthz.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Phase is the step of the resolution a request is sent for.
+type Phase int32
+
+const (
+	// PHASE_QUERY is the evaluation of a query, before it is resolved.
+	Phase_PHASE_QUERY Phase = 0
+	// PHASE_RESPONSE is the evaluation of the response of a resolved query.
+	Phase_PHASE_RESPONSE Phase = 1
+)
+
+// Enum value maps for Phase.
+var (
+	Phase_name = map[int32]string{
+		0: "PHASE_QUERY",
+		1: "PHASE_RESPONSE",
+	}
+	Phase_value = map[string]int32{
+		"PHASE_QUERY":    0,
+		"PHASE_RESPONSE": 1,
+	}
+)
+
+func (x Phase) Enum() *Phase {
+	p := new(Phase)
+	*p = x
+	return p
+}
+
+func (x Phase) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Phase) Descriptor() protoreflect.EnumDescriptor {
+	return file_extauthz_proto_enumTypes[0].Descriptor()
+}
+
+func (Phase) Type() protoreflect.EnumType {
+	return &file_extauthz_proto_enumTypes[0]
+}
+
+func (x Phase) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Phase.Descriptor instead.
+func (Phase) EnumDescriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{0}
+}
+
+// Action is the workflow action of the firewall plugin.
+type Action int32
+
+const (
+	// ACTION_NONE is no decision: the next rule of the firewall is evaluated.
+	Action_ACTION_NONE Action = 0
+	// ACTION_REFUSE replies with the REFUSED response code, unless an rcode is set.
+	Action_ACTION_REFUSE Action = 1
+	// ACTION_ALLOW continues the resolution, or sends the response as is.
+	Action_ACTION_ALLOW Action = 2
+	// ACTION_BLOCK replies with the NXDOMAIN response code, unless an rcode is set.
+	Action_ACTION_BLOCK Action = 3
+	// ACTION_DROP does not reply, the client will time out.
+	Action_ACTION_DROP Action = 4
+)
+
+// Enum value maps for Action.
+var (
+	Action_name = map[int32]string{
+		0: "ACTION_NONE",
+		1: "ACTION_REFUSE",
+		2: "ACTION_ALLOW",
+		3: "ACTION_BLOCK",
+		4: "ACTION_DROP",
+	}
+	Action_value = map[string]int32{
+		"ACTION_NONE":   0,
+		"ACTION_REFUSE": 1,
+		"ACTION_ALLOW":  2,
+		"ACTION_BLOCK":  3,
+		"ACTION_DROP":   4,
+	}
+)
+
+func (x Action) Enum() *Action {
+	p := new(Action)
+	*p = x
+	return p
+}
+
+func (x Action) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Action) Descriptor() protoreflect.EnumDescriptor {
+	return file_extauthz_proto_enumTypes[1].Descriptor()
+}
+
+func (Action) Type() protoreflect.EnumType {
+	return &file_extauthz_proto_enumTypes[1]
+}
+
+func (x Action) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Action.Descriptor instead.
+func (Action) EnumDescriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{1}
+}
+
+// Question is the question section of the query.
+type Question struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// name is the fully qualified domain name, e.g. "example.org."
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// type is the numeric type, e.g. 1 for A.
+	Type uint32 `protobuf:"varint,2,opt,name=type,proto3" json:"type,omitempty"`
+	// class is the numeric class, e.g. 1 for IN.
+	Class uint32 `protobuf:"varint,3,opt,name=class,proto3" json:"class,omitempty"`
+}
+
+func (x *Question) Reset() {
+	*x = Question{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Question) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Question) ProtoMessage() {}
+
+func (x *Question) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Question.ProtoReflect.Descriptor instead.
+func (*Question) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Question) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Question) GetType() uint32 {
+	if x != nil {
+		return x.Type
+	}
+	return 0
+}
+
+func (x *Question) GetClass() uint32 {
+	if x != nil {
+		return x.Class
+	}
+	return 0
+}
+
+// Client is the connection the query was received on.
+type Client struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// ip is the address of the client, without brackets for IPv6.
+	Ip   string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Port uint32 `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	// protocol is "udp" or "tcp".
+	Protocol string `protobuf:"bytes,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	// server_ip is the local address the query was received on.
+	ServerIp   string `protobuf:"bytes,4,opt,name=server_ip,json=serverIp,proto3" json:"server_ip,omitempty"`
+	ServerPort uint32 `protobuf:"varint,5,opt,name=server_port,json=serverPort,proto3" json:"server_port,omitempty"`
+}
+
+func (x *Client) Reset() {
+	*x = Client{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Client) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Client) ProtoMessage() {}
+
+func (x *Client) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Client.ProtoReflect.Descriptor instead.
+func (*Client) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Client) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *Client) GetPort() uint32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Client) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *Client) GetServerIp() string {
+	if x != nil {
+		return x.ServerIp
+	}
+	return ""
+}
+
+func (x *Client) GetServerPort() uint32 {
+	if x != nil {
+		return x.ServerPort
+	}
+	return 0
+}
+
+// EDNSOption is an EDNS0 option of the OPT record.
+type EDNSOption struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Code uint32 `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	// data is the wire format of the option data.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *EDNSOption) Reset() {
+	*x = EDNSOption{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EDNSOption) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EDNSOption) ProtoMessage() {}
+
+func (x *EDNSOption) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EDNSOption.ProtoReflect.Descriptor instead.
+func (*EDNSOption) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *EDNSOption) GetCode() uint32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *EDNSOption) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// EDNS is the OPT record of the query, if any.
+type EDNS struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// udp_size is the advertised UDP payload size.
+	UdpSize uint32 `protobuf:"varint,1,opt,name=udp_size,json=udpSize,proto3" json:"udp_size,omitempty"`
+	// do is the DNSSEC OK bit.
+	Do      bool          `protobuf:"varint,2,opt,name=do,proto3" json:"do,omitempty"`
+	Options []*EDNSOption `protobuf:"bytes,3,rep,name=options,proto3" json:"options,omitempty"`
+}
+
+func (x *EDNS) Reset() {
+	*x = EDNS{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EDNS) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EDNS) ProtoMessage() {}
+
+func (x *EDNS) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EDNS.ProtoReflect.Descriptor instead.
+func (*EDNS) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *EDNS) GetUdpSize() uint32 {
+	if x != nil {
+		return x.UdpSize
+	}
+	return 0
+}
+
+func (x *EDNS) GetDo() bool {
+	if x != nil {
+		return x.Do
+	}
+	return false
+}
+
+func (x *EDNS) GetOptions() []*EDNSOption {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+// ResourceRecord is a record of a section of the response.
+type ResourceRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type  uint32 `protobuf:"varint,2,opt,name=type,proto3" json:"type,omitempty"`
+	Class uint32 `protobuf:"varint,3,opt,name=class,proto3" json:"class,omitempty"`
+	Ttl   uint32 `protobuf:"varint,4,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	// rdata is the presentation format of the data, e.g. "192.0.2.1" for an A record.
+	Rdata string `protobuf:"bytes,5,opt,name=rdata,proto3" json:"rdata,omitempty"`
+}
+
+func (x *ResourceRecord) Reset() {
+	*x = ResourceRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ResourceRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResourceRecord) ProtoMessage() {}
+
+func (x *ResourceRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResourceRecord.ProtoReflect.Descriptor instead.
+func (*ResourceRecord) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ResourceRecord) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ResourceRecord) GetType() uint32 {
+	if x != nil {
+		return x.Type
+	}
+	return 0
+}
+
+func (x *ResourceRecord) GetClass() uint32 {
+	if x != nil {
+		return x.Class
+	}
+	return 0
+}
+
+func (x *ResourceRecord) GetTtl() uint32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+func (x *ResourceRecord) GetRdata() string {
+	if x != nil {
+		return x.Rdata
+	}
+	return ""
+}
+
+// Response is the response of the resolved query.
+type Response struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Rcode uint32 `protobuf:"varint,1,opt,name=rcode,proto3" json:"rcode,omitempty"`
+	// flags are the header flags that are set, e.g. "qr", "aa".
+	Flags      []string          `protobuf:"bytes,2,rep,name=flags,proto3" json:"flags,omitempty"`
+	Answer     []*ResourceRecord `protobuf:"bytes,3,rep,name=answer,proto3" json:"answer,omitempty"`
+	Authority  []*ResourceRecord `protobuf:"bytes,4,rep,name=authority,proto3" json:"authority,omitempty"`
+	Additional []*ResourceRecord `protobuf:"bytes,5,rep,name=additional,proto3" json:"additional,omitempty"`
+}
+
+func (x *Response) Reset() {
+	*x = Response{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Response) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Response) ProtoMessage() {}
+
+func (x *Response) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Response.ProtoReflect.Descriptor instead.
+func (*Response) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Response) GetRcode() uint32 {
+	if x != nil {
+		return x.Rcode
+	}
+	return 0
+}
+
+func (x *Response) GetFlags() []string {
+	if x != nil {
+		return x.Flags
+	}
+	return nil
+}
+
+func (x *Response) GetAnswer() []*ResourceRecord {
+	if x != nil {
+		return x.Answer
+	}
+	return nil
+}
+
+func (x *Response) GetAuthority() []*ResourceRecord {
+	if x != nil {
+		return x.Authority
+	}
+	return nil
+}
+
+func (x *Response) GetAdditional() []*ResourceRecord {
+	if x != nil {
+		return x.Additional
+	}
+	return nil
+}
+
+// CheckDNSRequest is the data of a query, and of its response in the response phase.
+type CheckDNSRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Phase Phase `protobuf:"varint,1,opt,name=phase,proto3,enum=coredns.policy.extauthz.v1.Phase" json:"phase,omitempty"`
+	// engine is the name of the extauthz engine sending the request.
+	Engine   string    `protobuf:"bytes,2,opt,name=engine,proto3" json:"engine,omitempty"`
+	Id       uint32    `protobuf:"varint,3,opt,name=id,proto3" json:"id,omitempty"`
+	Opcode   uint32    `protobuf:"varint,4,opt,name=opcode,proto3" json:"opcode,omitempty"`
+	Question *Question `protobuf:"bytes,5,opt,name=question,proto3" json:"question,omitempty"`
+	Client   *Client   `protobuf:"bytes,6,opt,name=client,proto3" json:"client,omitempty"`
+	// edns is not set if the query has no OPT record.
+	Edns *EDNS `protobuf:"bytes,7,opt,name=edns,proto3" json:"edns,omitempty"`
+	// metadata are the metadata of CoreDNS, by label.
+	Metadata map[string]string `protobuf:"bytes,8,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// response is only set in the response phase.
+	Response *Response `protobuf:"bytes,9,opt,name=response,proto3" json:"response,omitempty"`
+}
+
+func (x *CheckDNSRequest) Reset() {
+	*x = CheckDNSRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CheckDNSRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckDNSRequest) ProtoMessage() {}
+
+func (x *CheckDNSRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckDNSRequest.ProtoReflect.Descriptor instead.
+func (*CheckDNSRequest) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CheckDNSRequest) GetPhase() Phase {
+	if x != nil {
+		return x.Phase
+	}
+	return Phase_PHASE_QUERY
+}
+
+func (x *CheckDNSRequest) GetEngine() string {
+	if x != nil {
+		return x.Engine
+	}
+	return ""
+}
+
+func (x *CheckDNSRequest) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *CheckDNSRequest) GetOpcode() uint32 {
+	if x != nil {
+		return x.Opcode
+	}
+	return 0
+}
+
+func (x *CheckDNSRequest) GetQuestion() *Question {
+	if x != nil {
+		return x.Question
+	}
+	return nil
+}
+
+func (x *CheckDNSRequest) GetClient() *Client {
+	if x != nil {
+		return x.Client
+	}
+	return nil
+}
+
+func (x *CheckDNSRequest) GetEdns() *EDNS {
+	if x != nil {
+		return x.Edns
+	}
+	return nil
+}
+
+func (x *CheckDNSRequest) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *CheckDNSRequest) GetResponse() *Response {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+// ExtendedError is an Extended DNS Error (RFC 8914) added to the reply.
+type ExtendedError struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	InfoCode  uint32 `protobuf:"varint,1,opt,name=info_code,json=infoCode,proto3" json:"info_code,omitempty"`
+	ExtraText string `protobuf:"bytes,2,opt,name=extra_text,json=extraText,proto3" json:"extra_text,omitempty"`
+}
+
+func (x *ExtendedError) Reset() {
+	*x = ExtendedError{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExtendedError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtendedError) ProtoMessage() {}
+
+func (x *ExtendedError) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtendedError.ProtoReflect.Descriptor instead.
+func (*ExtendedError) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ExtendedError) GetInfoCode() uint32 {
+	if x != nil {
+		return x.InfoCode
+	}
+	return 0
+}
+
+func (x *ExtendedError) GetExtraText() string {
+	if x != nil {
+		return x.ExtraText
+	}
+	return ""
+}
+
+// CheckDNSResponse is the decision for a query or a response.
+type CheckDNSResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Action Action `protobuf:"varint,1,opt,name=action,proto3,enum=coredns.policy.extauthz.v1.Action" json:"action,omitempty"`
+	// rcode replaces the response code of the REFUSE and BLOCK actions. If it is not set, the response code
+	// is NOERROR if answer is set, REFUSED or NXDOMAIN otherwise.
+	Rcode *uint32 `protobuf:"varint,2,opt,name=rcode,proto3,oneof" json:"rcode,omitempty"`
+	// extended_error is added to the reply of the REFUSE and BLOCK actions.
+	ExtendedError *ExtendedError `protobuf:"bytes,3,opt,name=extended_error,json=extendedError,proto3" json:"extended_error,omitempty"`
+	// answer are the records added to the reply of the REFUSE and BLOCK actions, in presentation format,
+	// e.g. "example.org. 60 IN A 192.0.2.1", to redirect the client.
+	Answer []string `protobuf:"bytes,4,rep,name=answer,proto3" json:"answer,omitempty"`
+	// metadata are set as metadata of CoreDNS, for the plugins evaluated after the firewall.
+	Metadata map[string]string `protobuf:"bytes,5,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *CheckDNSResponse) Reset() {
+	*x = CheckDNSResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_extauthz_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CheckDNSResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckDNSResponse) ProtoMessage() {}
+
+func (x *CheckDNSResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_extauthz_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckDNSResponse.ProtoReflect.Descriptor instead.
+func (*CheckDNSResponse) Descriptor() ([]byte, []int) {
+	return file_extauthz_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CheckDNSResponse) GetAction() Action {
+	if x != nil {
+		return x.Action
+	}
+	return Action_ACTION_NONE
+}
+
+func (x *CheckDNSResponse) GetRcode() uint32 {
+	if x != nil && x.Rcode != nil {
+		return *x.Rcode
+	}
+	return 0
+}
+
+func (x *CheckDNSResponse) GetExtendedError() *ExtendedError {
+	if x != nil {
+		return x.ExtendedError
+	}
+	return nil
+}
+
+func (x *CheckDNSResponse) GetAnswer() []string {
+	if x != nil {
+		return x.Answer
+	}
+	return nil
+}
+
+func (x *CheckDNSResponse) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+var File_extauthz_proto protoreflect.FileDescriptor
+
+var file_extauthz_proto_rawDesc = []byte{
+	0x0a, 0x0e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x1a, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79,
+	0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x22, 0x48, 0x0a, 0x08,
+	0x51, 0x75, 0x65, 0x73, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04,
+	0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65,
+	0x12, 0x14, 0x0a, 0x05, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x05, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x22, 0x86, 0x01, 0x0a, 0x06, 0x43, 0x6c, 0x69, 0x65, 0x6e,
+	0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
+	0x70, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x04, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f,
+	0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f,
+	0x6c, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x5f, 0x69, 0x70, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x70, 0x12, 0x1f,
+	0x0a, 0x0b, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x0a, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x50, 0x6f, 0x72, 0x74, 0x22,
+	0x34, 0x0a, 0x0a, 0x45, 0x44, 0x4e, 0x53, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a,
+	0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x63, 0x6f, 0x64,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x73, 0x0a, 0x04, 0x45, 0x44, 0x4e, 0x53, 0x12, 0x19, 0x0a,
+	0x08, 0x75, 0x64, 0x70, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x07, 0x75, 0x64, 0x70, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x64, 0x6f, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x64, 0x6f, 0x12, 0x40, 0x0a, 0x07, 0x6f, 0x70, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x26, 0x2e, 0x63, 0x6f, 0x72, 0x65,
+	0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75,
+	0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x44, 0x4e, 0x53, 0x4f, 0x70, 0x74, 0x69, 0x6f,
+	0x6e, 0x52, 0x07, 0x6f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x22, 0x76, 0x0a, 0x0e, 0x52, 0x65,
+	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x12, 0x0a, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04,
+	0x74, 0x79, 0x70, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x05, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x74,
+	0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x74, 0x74, 0x6c, 0x12, 0x14, 0x0a, 0x05,
+	0x72, 0x64, 0x61, 0x74, 0x61, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x72, 0x64, 0x61,
+	0x74, 0x61, 0x22, 0x90, 0x02, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x14, 0x0a, 0x05, 0x72, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05,
+	0x72, 0x63, 0x6f, 0x64, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x6c, 0x61, 0x67, 0x73, 0x18, 0x02,
+	0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x66, 0x6c, 0x61, 0x67, 0x73, 0x12, 0x42, 0x0a, 0x06, 0x61,
+	0x6e, 0x73, 0x77, 0x65, 0x72, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2a, 0x2e, 0x63, 0x6f,
+	0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74,
+	0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63,
+	0x65, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x06, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x12,
+	0x48, 0x0a, 0x09, 0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x18, 0x04, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x2a, 0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c,
+	0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e,
+	0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x09,
+	0x61, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x12, 0x4a, 0x0a, 0x0a, 0x61, 0x64, 0x64,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2a, 0x2e,
+	0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65,
+	0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75,
+	0x72, 0x63, 0x65, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x0a, 0x61, 0x64, 0x64, 0x69, 0x74,
+	0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x22, 0x94, 0x04, 0x0a, 0x0f, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x44,
+	0x4e, 0x53, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x37, 0x0a, 0x05, 0x70, 0x68, 0x61,
+	0x73, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x21, 0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64,
+	0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74,
+	0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x68, 0x61, 0x73, 0x65, 0x52, 0x05, 0x70, 0x68, 0x61,
+	0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x65, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x65, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x02, 0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x70,
+	0x63, 0x6f, 0x64, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x6f, 0x70, 0x63, 0x6f,
+	0x64, 0x65, 0x12, 0x40, 0x0a, 0x08, 0x71, 0x75, 0x65, 0x73, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x24, 0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70,
+	0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76,
+	0x31, 0x2e, 0x51, 0x75, 0x65, 0x73, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x3a, 0x0a, 0x06, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70,
+	0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76,
+	0x31, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x52, 0x06, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74,
+	0x12, 0x34, 0x0a, 0x04, 0x65, 0x64, 0x6e, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x20,
+	0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e,
+	0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x44, 0x4e, 0x53,
+	0x52, 0x04, 0x65, 0x64, 0x6e, 0x73, 0x12, 0x55, 0x0a, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x39, 0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64,
+	0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74,
+	0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x44, 0x4e, 0x53, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x52, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x12, 0x40, 0x0a,
+	0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x24, 0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79,
+	0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x52, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x1a,
+	0x3b, 0x0a, 0x0d, 0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
+	0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x4b, 0x0a, 0x0d,
+	0x45, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x1b, 0x0a,
+	0x09, 0x69, 0x6e, 0x66, 0x6f, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x08, 0x69, 0x6e, 0x66, 0x6f, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x78,
+	0x74, 0x72, 0x61, 0x5f, 0x74, 0x65, 0x78, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x65, 0x78, 0x74, 0x72, 0x61, 0x54, 0x65, 0x78, 0x74, 0x22, 0xf2, 0x02, 0x0a, 0x10, 0x43, 0x68,
+	0x65, 0x63, 0x6b, 0x44, 0x4e, 0x53, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3a,
+	0x0a, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x22,
+	0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e,
+	0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x63, 0x74, 0x69,
+	0x6f, 0x6e, 0x52, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x19, 0x0a, 0x05, 0x72, 0x63,
+	0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x00, 0x52, 0x05, 0x72, 0x63, 0x6f,
+	0x64, 0x65, 0x88, 0x01, 0x01, 0x12, 0x50, 0x0a, 0x0e, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65,
+	0x64, 0x5f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x29, 0x2e,
+	0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65,
+	0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x78, 0x74, 0x65, 0x6e,
+	0x64, 0x65, 0x64, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x52, 0x0d, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64,
+	0x65, 0x64, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x61, 0x6e, 0x73, 0x77, 0x65,
+	0x72, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x12,
+	0x56, 0x0a, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x05, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x3a, 0x2e, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69,
+	0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x43,
+	0x68, 0x65, 0x63, 0x6b, 0x44, 0x4e, 0x53, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e,
+	0x4d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x08, 0x6d,
+	0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x1a, 0x3b, 0x0a, 0x0d, 0x4d, 0x65, 0x74, 0x61, 0x64,
+	0x61, 0x74, 0x61, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x3a, 0x02, 0x38, 0x01, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x72, 0x63, 0x6f, 0x64, 0x65, 0x2a, 0x2c,
+	0x0a, 0x05, 0x50, 0x68, 0x61, 0x73, 0x65, 0x12, 0x0f, 0x0a, 0x0b, 0x50, 0x48, 0x41, 0x53, 0x45,
+	0x5f, 0x51, 0x55, 0x45, 0x52, 0x59, 0x10, 0x00, 0x12, 0x12, 0x0a, 0x0e, 0x50, 0x48, 0x41, 0x53,
+	0x45, 0x5f, 0x52, 0x45, 0x53, 0x50, 0x4f, 0x4e, 0x53, 0x45, 0x10, 0x01, 0x2a, 0x61, 0x0a, 0x06,
+	0x41, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x0f, 0x0a, 0x0b, 0x41, 0x43, 0x54, 0x49, 0x4f, 0x4e,
+	0x5f, 0x4e, 0x4f, 0x4e, 0x45, 0x10, 0x00, 0x12, 0x11, 0x0a, 0x0d, 0x41, 0x43, 0x54, 0x49, 0x4f,
+	0x4e, 0x5f, 0x52, 0x45, 0x46, 0x55, 0x53, 0x45, 0x10, 0x01, 0x12, 0x10, 0x0a, 0x0c, 0x41, 0x43,
+	0x54, 0x49, 0x4f, 0x4e, 0x5f, 0x41, 0x4c, 0x4c, 0x4f, 0x57, 0x10, 0x02, 0x12, 0x10, 0x0a, 0x0c,
+	0x41, 0x43, 0x54, 0x49, 0x4f, 0x4e, 0x5f, 0x42, 0x4c, 0x4f, 0x43, 0x4b, 0x10, 0x03, 0x12, 0x0f,
+	0x0a, 0x0b, 0x41, 0x43, 0x54, 0x49, 0x4f, 0x4e, 0x5f, 0x44, 0x52, 0x4f, 0x50, 0x10, 0x04, 0x32,
+	0x76, 0x0a, 0x0d, 0x41, 0x75, 0x74, 0x68, 0x6f, 0x72, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x65, 0x0a, 0x08, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x44, 0x4e, 0x53, 0x12, 0x2b, 0x2e, 0x63,
+	0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78,
+	0x74, 0x61, 0x75, 0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x44,
+	0x4e, 0x53, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x2c, 0x2e, 0x63, 0x6f, 0x72, 0x65,
+	0x64, 0x6e, 0x73, 0x2e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x2e, 0x65, 0x78, 0x74, 0x61, 0x75,
+	0x74, 0x68, 0x7a, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x44, 0x4e, 0x53, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2e, 0x5a, 0x2c, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x63, 0x6f, 0x72, 0x65, 0x64, 0x6e, 0x73, 0x2f, 0x70, 0x6f,
+	0x6c, 0x69, 0x63, 0x79, 0x2f, 0x70, 0x6c, 0x75, 0x67, 0x69, 0x6e, 0x2f, 0x65, 0x78, 0x74, 0x61,
+	0x75, 0x74, 0x68, 0x7a, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_extauthz_proto_rawDescOnce sync.Once
+	file_extauthz_proto_rawDescData = file_extauthz_proto_rawDesc
+)
+
+func file_extauthz_proto_rawDescGZIP() []byte {
+	file_extauthz_proto_rawDescOnce.Do(func() {
+		file_extauthz_proto_rawDescData = protoimpl.X.CompressGZIP(file_extauthz_proto_rawDescData)
+	})
+	return file_extauthz_proto_rawDescData
+}
+
+var file_extauthz_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_extauthz_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_extauthz_proto_goTypes = []interface{}{
+	(Phase)(0),               // 0: coredns.policy.extauthz.v1.Phase
+	(Action)(0),              // 1: coredns.policy.extauthz.v1.Action
+	(*Question)(nil),         // 2: coredns.policy.extauthz.v1.Question
+	(*Client)(nil),           // 3: coredns.policy.extauthz.v1.Client
+	(*EDNSOption)(nil),       // 4: coredns.policy.extauthz.v1.EDNSOption
+	(*EDNS)(nil),             // 5: coredns.policy.extauthz.v1.EDNS
+	(*ResourceRecord)(nil),   // 6: coredns.policy.extauthz.v1.ResourceRecord
+	(*Response)(nil),         // 7: coredns.policy.extauthz.v1.Response
+	(*CheckDNSRequest)(nil),  // 8: coredns.policy.extauthz.v1.CheckDNSRequest
+	(*ExtendedError)(nil),    // 9: coredns.policy.extauthz.v1.ExtendedError
+	(*CheckDNSResponse)(nil), // 10: coredns.policy.extauthz.v1.CheckDNSResponse
+	nil,                      // 11: coredns.policy.extauthz.v1.CheckDNSRequest.MetadataEntry
+	nil,                      // 12: coredns.policy.extauthz.v1.CheckDNSResponse.MetadataEntry
+}
+var file_extauthz_proto_depIdxs = []int32{
+	4,  // 0: coredns.policy.extauthz.v1.EDNS.options:type_name -> coredns.policy.extauthz.v1.EDNSOption
+	6,  // 1: coredns.policy.extauthz.v1.Response.answer:type_name -> coredns.policy.extauthz.v1.ResourceRecord
+	6,  // 2: coredns.policy.extauthz.v1.Response.authority:type_name -> coredns.policy.extauthz.v1.ResourceRecord
+	6,  // 3: coredns.policy.extauthz.v1.Response.additional:type_name -> coredns.policy.extauthz.v1.ResourceRecord
+	0,  // 4: coredns.policy.extauthz.v1.CheckDNSRequest.phase:type_name -> coredns.policy.extauthz.v1.Phase
+	2,  // 5: coredns.policy.extauthz.v1.CheckDNSRequest.question:type_name -> coredns.policy.extauthz.v1.Question
+	3,  // 6: coredns.policy.extauthz.v1.CheckDNSRequest.client:type_name -> coredns.policy.extauthz.v1.Client
+	5,  // 7: coredns.policy.extauthz.v1.CheckDNSRequest.edns:type_name -> coredns.policy.extauthz.v1.EDNS
+	11, // 8: coredns.policy.extauthz.v1.CheckDNSRequest.metadata:type_name -> coredns.policy.extauthz.v1.CheckDNSRequest.MetadataEntry
+	7,  // 9: coredns.policy.extauthz.v1.CheckDNSRequest.response:type_name -> coredns.policy.extauthz.v1.Response
+	1,  // 10: coredns.policy.extauthz.v1.CheckDNSResponse.action:type_name -> coredns.policy.extauthz.v1.Action
+	9,  // 11: coredns.policy.extauthz.v1.CheckDNSResponse.extended_error:type_name -> coredns.policy.extauthz.v1.ExtendedError
+	12, // 12: coredns.policy.extauthz.v1.CheckDNSResponse.metadata:type_name -> coredns.policy.extauthz.v1.CheckDNSResponse.MetadataEntry
+	8,  // 13: coredns.policy.extauthz.v1.Authorization.CheckDNS:input_type -> coredns.policy.extauthz.v1.CheckDNSRequest
+	10, // 14: coredns.policy.extauthz.v1.Authorization.CheckDNS:output_type -> coredns.policy.extauthz.v1.CheckDNSResponse
+	14, // [14:15] is the sub-list for method output_type
+	13, // [13:14] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_extauthz_proto_init() }
+func file_extauthz_proto_init() {
+	if File_extauthz_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_extauthz_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Question); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Client); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EDNSOption); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EDNS); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ResourceRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Response); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CheckDNSRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExtendedError); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_extauthz_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CheckDNSResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_extauthz_proto_msgTypes[8].OneofWrappers = []interface{}{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_extauthz_proto_rawDesc,
+			NumEnums:      2,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_extauthz_proto_goTypes,
+		DependencyIndexes: file_extauthz_proto_depIdxs,
+		EnumInfos:         file_extauthz_proto_enumTypes,
+		MessageInfos:      file_extauthz_proto_msgTypes,
+	}.Build()
+	File_extauthz_proto = out.File
+	file_extauthz_proto_rawDesc = nil
+	file_extauthz_proto_goTypes = nil
+	file_extauthz_proto_depIdxs = nil
+}
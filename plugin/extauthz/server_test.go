@@ -0,0 +1,54 @@
+package extauthz
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/coredns/policy/plugin/extauthz/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// testServer is a reference implementation of the Authorization service, running in-process.
+// It returns the decision of a function of the request, and records the requests received
+type testServer struct {
+	pb.UnimplementedAuthorizationServer
+	decide func(ctx context.Context, req *pb.CheckDNSRequest) (*pb.CheckDNSResponse, error)
+
+	sync.Mutex
+	requests []*pb.CheckDNSRequest
+}
+
+// CheckDNS implements the pb.AuthorizationServer interface
+func (s *testServer) CheckDNS(ctx context.Context, req *pb.CheckDNSRequest) (*pb.CheckDNSResponse, error) {
+	s.Lock()
+	s.requests = append(s.requests, proto.Clone(req).(*pb.CheckDNSRequest))
+	s.Unlock()
+	return s.decide(ctx, req)
+}
+
+// last return the last request received
+func (s *testServer) last() *pb.CheckDNSRequest {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+// startServer starts the service on a local port, and return its address. The service is stopped at the end of the test
+func startServer(t *testing.T, s *testServer) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterAuthorizationServer(srv, s)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
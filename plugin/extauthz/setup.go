@@ -0,0 +1,129 @@
+package extauthz
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func init() {
+	caddy.RegisterPlugin("extauthz", caddy.Plugin{
+		ServerType: "dns",
+		Action:     setup,
+	})
+}
+
+func setup(c *caddy.Controller) error {
+	p, err := parse(c)
+	if err != nil {
+		return plugin.Error("extauthz", err)
+	}
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		p.next = next
+		return p
+	})
+	// the connections are opened at startup, so that none is left open if the parsing of the Corefile fails
+	for _, e := range p.engines {
+		e := e
+		c.OnStartup(func() error {
+			if err := e.connect(); err != nil {
+				return plugin.Error("extauthz", fmt.Errorf("cannot connect to %s : %s", e.endpoint, err))
+			}
+			return nil
+		})
+		c.OnShutdown(func() error {
+			e.close()
+			return nil
+		})
+	}
+	return nil
+}
+
+func parse(c *caddy.Controller) (*extauthz, error) {
+	p := newExtauthz()
+	mapping := rqdata.NewMapping("")
+	for c.Next() {
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		name := args[0]
+		if _, ok := p.engines[name]; ok {
+			return nil, c.Errf("extauthz engine %s is already declared", name)
+		}
+		e := newEngine(name, mapping)
+		var tlsConfig *tls.Config
+		for c.NextBlock() {
+			switch c.Val() {
+			case "endpoint":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				e.endpoint = args[0]
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("invalid timeout '%s'", args[0])
+				}
+				e.timeout = d
+			case "connections":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					return nil, c.Errf("invalid number of connections '%s'", args[0])
+				}
+				e.size = n
+			case "tls": // [[cert key] cacert]
+				args := c.RemainingArgs()
+				if len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				var err error
+				tlsConfig, err = pkgtls.NewTLSConfigFromArgs(args...)
+				if err != nil {
+					return nil, err
+				}
+			case "tls_servername":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				if tlsConfig == nil {
+					return nil, c.Err("tls_servername requires tls")
+				}
+				tlsConfig.ServerName = args[0]
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+		if e.endpoint == "" {
+			return nil, c.Err("endpoint required")
+		}
+		creds := insecure.NewCredentials()
+		if tlsConfig != nil {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		e.opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		p.engines[name] = e
+	}
+	return p, nil
+}
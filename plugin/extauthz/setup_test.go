@@ -0,0 +1,107 @@
+package extauthz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input       string
+		shouldErr   bool
+		engines     []string
+		timeout     time.Duration
+		connections int
+	}{
+		{`extauthz`, true, nil, 0, 0},
+		{`extauthz myengine`, true, nil, 0, 0},
+		{`extauthz myengine {
+			endpoint localhost:9191
+		}`, false, []string{"myengine"}, time.Second, 1},
+		{`extauthz myengine {
+			endpoint localhost:9191
+			timeout 50ms
+			connections 4
+		}`, false, []string{"myengine"}, 50 * time.Millisecond, 4},
+		{`extauthz myengine {
+			endpoint localhost:9191
+			tls
+			tls_servername authz.example.org
+		}`, false, []string{"myengine"}, time.Second, 1},
+		{`extauthz myengine {
+			endpoint localhost:9191
+			tls_servername authz.example.org
+		}`, true, nil, 0, 0},
+		{`extauthz myengine {
+			endpoint localhost:9191
+			tls missing.crt missing.key missing.ca
+		}`, true, nil, 0, 0},
+		{`extauthz myengine {
+			endpoint localhost:9191
+			timeout 0s
+		}`, true, nil, 0, 0},
+		{`extauthz myengine {
+			endpoint localhost:9191
+			connections 0
+		}`, true, nil, 0, 0},
+		{`extauthz myengine {
+			endpoint localhost:9191
+			unknown
+		}`, true, nil, 0, 0},
+		{`extauthz myengine {
+			timeout 1s
+		}`, true, nil, 0, 0},
+		{`extauthz myengine {
+			endpoint localhost:9191
+		}
+		extauthz other {
+			endpoint localhost:9192
+		}`, false, []string{"myengine", "other"}, time.Second, 1},
+		{`extauthz myengine {
+			endpoint localhost:9191
+		}
+		extauthz myengine {
+			endpoint localhost:9191
+		}`, true, nil, 0, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		p, err := parse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but didn't get one for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but got one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if len(p.engines) != len(test.engines) {
+			t.Errorf("Test %d: expected %d engines, got %d", i, len(test.engines), len(p.engines))
+		}
+		for _, name := range test.engines {
+			e := p.engines[name]
+			if e == nil {
+				t.Errorf("Test %d: expected engine %s to be declared", i, name)
+				continue
+			}
+			if e.timeout != test.timeout {
+				t.Errorf("Test %d: expected timeout %s, got %s", i, test.timeout, e.timeout)
+			}
+			// the connections are opened at startup
+			if len(e.conns) != 0 {
+				t.Errorf("Test %d: expected no connection before startup, got %d", i, len(e.conns))
+			}
+			if err := e.connect(); err != nil {
+				t.Fatalf("Test %d: unexpected error at connect : %s", i, err)
+			}
+			if len(e.conns) != test.connections {
+				t.Errorf("Test %d: expected %d connections, got %d", i, test.connections, len(e.conns))
+			}
+			e.close()
+		}
+	}
+}
@@ -46,6 +46,11 @@ func New() (*firewall, error) {
 	return pol, nil
 }
 
+// exprEngine return the built-in Expression engine of the firewall
+func (p *firewall) exprEngine() *policy.ExprEngine {
+	return p.engines[ExpressionEngineName].(*policy.ExprEngine)
+}
+
 // ServeDNS implements the Handler interface.
 func (p *firewall) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	var (
@@ -58,7 +63,7 @@ func (p *firewall) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	state := request.Request{W: w, Req: r}
 
 	// evaluate query to determine action
-	action, err := p.query.Evaluate(ctx, state, queryData, p.engines)
+	decision, err := p.query.Decide(ctx, state, queryData, p.engines)
 	if err != nil {
 		m := new(dns.Msg)
 		m = m.SetRcode(r, dns.RcodeServerFailure)
@@ -66,7 +71,7 @@ func (p *firewall) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 		return dns.RcodeSuccess, err
 	}
 
-	if action == policy.TypeAllow {
+	if decision.Action == policy.TypeAllow {
 		// if Allow : ask next plugin to resolve the DNS query
 		// temp writer: hold the DNS response until evaluation of the Reply Rulelist
 		writer := nonwriter.New(w)
@@ -86,7 +91,7 @@ func (p *firewall) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 		stateReply := request.Request{W: reader, Req: respMsg}
 
 		// whatever the response, send to the Reply RuleList for action
-		action, err = p.reply.Evaluate(ctx, stateReply, queryData, p.engines)
+		decision, err = p.reply.Decide(ctx, stateReply, queryData, p.engines)
 		if err != nil {
 			m := new(dns.Msg)
 			m = m.SetRcode(r, dns.RcodeServerFailure)
@@ -96,7 +101,7 @@ func (p *firewall) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 	}
 
 	// Now apply the action evaluated by the RuleLists
-	switch action {
+	switch decision.Action {
 	case policy.TypeAllow:
 		// the response from next plugin, whatever it is, is good to go
 		w.WriteMsg(respMsg)
@@ -115,12 +120,33 @@ func (p *firewall) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Ms
 		status = dns.RcodeServerFailure
 		errfw = errInvalidAction
 	}
+	if errfw != nil {
+		return dns.RcodeSuccess, errfw
+	}
+	w.WriteMsg(reply(r, decision, status))
+	return dns.RcodeSuccess, nil
+}
+
+// reply builds the response to the request for a refuse or block decision, where status is the default rcode of the action
+func reply(r *dns.Msg, d *policy.Decision, status int) *dns.Msg {
+	if len(d.Answer) > 0 {
+		status = dns.RcodeSuccess
+	}
+	if d.Rcode != nil {
+		status = *d.Rcode
+	}
 	m := new(dns.Msg)
 	m.SetRcode(r, status)
-	if errfw == nil {
-		w.WriteMsg(m)
+	m.Answer = append(m.Answer, d.Answer...)
+	// Extended DNS Errors and extended rcodes are only sent to clients that support EDNS0,
+	// the upper bits of an extended rcode are sent in the OPT record
+	if opt := r.IsEdns0(); opt != nil && (d.ExtendedError != nil || status > 0xf) {
+		o := m.SetEdns0(opt.UDPSize(), opt.Do()).IsEdns0()
+		if d.ExtendedError != nil {
+			o.Option = append(o.Option, d.ExtendedError)
+		}
 	}
-	return dns.RcodeSuccess, errfw
+	return m
 }
 
 // Name implements the Handler interface.
@@ -6,7 +6,9 @@ import (
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
 	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/firewall/rule"
 	"github.com/coredns/policy/plugin/pkg/response"
 	"github.com/miekg/dns"
 )
@@ -82,3 +84,88 @@ func TestFirewallResolution(t *testing.T) {
 
 	}
 }
+
+// decisionEngine is a policy engine whose rules always return the same Decision
+type decisionEngine struct {
+	decision *policy.Decision
+}
+
+func (e *decisionEngine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func (e *decisionEngine) BuildReplyData(ctx context.Context, state request.Request, queryData interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func (e *decisionEngine) BuildRule(args []string) (policy.Rule, error) { return e, nil }
+
+func (e *decisionEngine) Evaluate(data interface{}) (int, error) { return e.decision.Action, nil }
+
+func (e *decisionEngine) Decide(data interface{}) (*policy.Decision, error) { return e.decision, nil }
+
+func TestFirewallDecision(t *testing.T) {
+	servfail := dns.RcodeServerFailure
+	badcookie := dns.RcodeBadCookie
+	ede := &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeBlocked, ExtraText: "malware"}
+
+	tests := []struct {
+		decision *policy.Decision
+		edns     bool
+		msgCode  int
+		answer   int
+		ede      bool
+	}{
+		{&policy.Decision{Action: policy.TypeBlock}, false, dns.RcodeNameError, 0, false},
+		{&policy.Decision{Action: policy.TypeRefuse, Rcode: &servfail}, false, dns.RcodeServerFailure, 0, false},
+		{&policy.Decision{Action: policy.TypeBlock, Answer: []dns.RR{test.A("example.com. 60 IN A 192.0.2.1")}}, false, dns.RcodeSuccess, 1, false},
+		{&policy.Decision{Action: policy.TypeBlock, ExtendedError: ede}, false, dns.RcodeNameError, 0, false},
+		{&policy.Decision{Action: policy.TypeBlock, ExtendedError: ede}, true, dns.RcodeNameError, 0, true},
+		{&policy.Decision{Action: policy.TypeRefuse, Rcode: &badcookie}, true, dns.RcodeBadCookie, 0, false},
+	}
+
+	ctx := context.TODO()
+	for i, tc := range tests {
+		fw, _ := New()
+		fw.engines["decision"] = &decisionEngine{tc.decision}
+		fw.query.Add(&rule.Element{Plugin: "test", Name: "decision"})
+		if err := fw.query.BuildRules(fw.engines); err != nil {
+			t.Fatal(err)
+		}
+		fw.next = ProcessHandler(dns.RcodeSuccess, nil)
+
+		req := new(dns.Msg)
+		req.SetQuestion("example.com.", dns.TypeA)
+		if tc.edns {
+			req.SetEdns0(4096, false)
+		}
+
+		rec := response.NewReader(&test.ResponseWriter{})
+		if _, err := fw.ServeDNS(ctx, rec, req); err != nil {
+			t.Fatalf("Test %d: Expected no error, but got %s", i, err)
+		}
+		if rec.Msg == nil {
+			t.Fatalf("Test %d: Expected a reply", i)
+		}
+		if rec.Msg.Rcode != tc.msgCode {
+			t.Errorf("Test %d: Expected value %s as DNS reply code, but got %s", i, dns.RcodeToString[tc.msgCode], dns.RcodeToString[rec.Msg.Rcode])
+		}
+		if len(rec.Msg.Answer) != tc.answer {
+			t.Errorf("Test %d: Expected %d answer records, but got %d", i, tc.answer, len(rec.Msg.Answer))
+		}
+		found := false
+		if opt := rec.Msg.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_EDE); ok && e.InfoCode == ede.InfoCode && e.ExtraText == ede.ExtraText {
+					found = true
+				}
+			}
+		}
+		if found != tc.ede {
+			t.Errorf("Test %d: Expected extended error in reply : %v, but got %v", i, tc.ede, found)
+		}
+		if _, err := rec.Msg.Pack(); err != nil {
+			t.Errorf("Test %d: Expected a valid reply, but got %s", i, err)
+		}
+	}
+}
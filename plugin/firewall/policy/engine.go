@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
 )
 
 const (
@@ -39,6 +41,26 @@ type Rule interface {
 	Evaluate(data interface{}) (int, error)
 }
 
+// Decision is the result of a Rule that decides the reply to send, in addition to the action
+type Decision struct {
+	// Action is one of the TypeXXX defined above
+	Action int
+	// Rcode of the reply of TypeRefuse and TypeBlock. If nil, it is NOERROR if there are Answer records,
+	// otherwise REFUSED or NXDOMAIN
+	Rcode *int
+	// Answer records added to the reply of TypeRefuse and TypeBlock, e.g. to redirect the client
+	Answer []dns.RR
+	// ExtendedError added to the reply of TypeRefuse and TypeBlock, if the query supports EDNS0
+	ExtendedError *dns.EDNS0_EDE
+}
+
+// Decider is implemented by the Rules that can decide the reply to send.
+// The firewall calls Decide instead of Evaluate for these Rules
+type Decider interface {
+	// Decide evaluate the rule and return the Decision. The Action follows the same convention as Evaluate
+	Decide(data interface{}) (*Decision, error)
+}
+
 // Engine for Firewall plugin
 type Engine interface {
 	// BuildRules - create a Rule based on args or throw an error, This Rule will be evaluated during processing of DNS Queries
@@ -2,6 +2,7 @@ package policy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -15,6 +16,8 @@ import (
 	expr "github.com/Knetic/govaluate"
 )
 
+var errNoGeoIP = errors.New("no geoip database is configured")
+
 type ruleExpr struct {
 	action        int
 	actionIfError int
@@ -26,26 +29,47 @@ type ruleExpr struct {
 type ExprEngine struct {
 	actionIfErrorEvaluation int
 	dataFromReq             *rqdata.Mapping
+	geoip                   *GeoIP
+	typedValues             bool
+	metadata                []string
+	validation              int
 }
 
 type dataAsParam struct {
 	ctx         context.Context
 	dataFromReq *rqdata.Extractor
+	typedValues bool
 }
 
 // NewExprEngine create a new Engine with default configuration
 func NewExprEngine() *ExprEngine {
-	return &ExprEngine{TypeRefuse, rqdata.NewMapping("")}
+	return &ExprEngine{actionIfErrorEvaluation: TypeRefuse, dataFromReq: rqdata.NewMapping(""), validation: ValidationWarn}
+}
+
+// SetGeoIP defines the databases used by the functions country, asn and asorg
+func (x *ExprEngine) SetGeoIP(g *GeoIP) {
+	x.geoip = g
+}
+
+// SetTypedValues defines if the data of the request are provided to expressions with their natural type
+// (numbers, booleans, list of flags) instead of strings
+func (x *ExprEngine) SetTypedValues(typed bool) {
+	x.typedValues = typed
+}
+
+// GeoIP return the databases used by the functions country, asn and asorg, or nil if none is defined
+func (x *ExprEngine) GeoIP() *GeoIP {
+	return x.geoip
 }
 
 //BuildQueryData here return a dataAsParam that can be used by to evaluate the variables of the expression
 func (x *ExprEngine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
-	return &dataAsParam{ctx, rqdata.NewExtractor(state, x.dataFromReq)}, nil
+	return &dataAsParam{ctx, rqdata.NewExtractor(state, x.dataFromReq), x.typedValues}, nil
 }
 
 //BuildReplyData here return a dataAsParam that can be used by to evaluate the variables of the expression
 func (x *ExprEngine) BuildReplyData(ctx context.Context, state request.Request, query interface{}) (interface{}, error) {
-	return &dataAsParam{ctx, rqdata.NewExtractor(state, x.dataFromReq)}, nil
+	return &dataAsParam{ctx, rqdata.NewExtractor(state, x.dataFromReq), x.typedValues}, nil
 }
 
 //BuildRule create a rule for Expression Engine:
@@ -54,14 +78,24 @@ func (x *ExprEngine) BuildReplyData(ctx context.Context, state request.Request,
 func (x *ExprEngine) BuildRule(args []string) (Rule, error) {
 	keyword := args[0]
 	exp := args[1:]
-	e, err := expr.NewEvaluableExpressionWithFunctions(strings.Join(exp, " "), map[string]expr.ExpressionFunction{
+	functions := map[string]expr.ExpressionFunction{
 		"atoi":   atoi,
 		"incidr": incidr,
 		"random": random,
-	})
+	}
+	if x.geoip != nil {
+		// geoip functions are only defined if the databases are
+		functions["country"] = x.country
+		functions["asn"] = x.asn
+		functions["asorg"] = x.asorg
+	}
+	e, err := expr.NewEvaluableExpressionWithFunctions(strings.Join(exp, " "), functions)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create a valid expression : %s", err)
 	}
+	if err := x.validate(e); err != nil {
+		return nil, err
+	}
 
 	var kind = TypeNone
 	for k, n := range NameTypes {
@@ -86,6 +120,10 @@ func atoi(arguments ...interface{}) (interface{}, error) {
 	if len(arguments) != 1 {
 		return nil, fmt.Errorf("atoi requires exactly one string argument")
 	}
+	if f, ok := arguments[0].(float64); ok {
+		// already a number, as the typed values of the request
+		return f, nil
+	}
 	s, ok := arguments[0].(string)
 	if !ok {
 		return nil, fmt.Errorf("atoi requires exactly one string argument")
@@ -112,6 +150,41 @@ func incidr(args ...interface{}) (interface{}, error) {
 	return cidr.Contains(ip), nil
 }
 
+func (x *ExprEngine) country(args ...interface{}) (interface{}, error) {
+	if x.geoip == nil {
+		return nil, errNoGeoIP
+	}
+	ip, err := geoIPArg("country", args)
+	if err != nil || ip == nil {
+		return "", err
+	}
+	return x.geoip.Country(ip)
+}
+
+func (x *ExprEngine) asn(args ...interface{}) (interface{}, error) {
+	if x.geoip == nil {
+		return nil, errNoGeoIP
+	}
+	ip, err := geoIPArg("asn", args)
+	if err != nil || ip == nil {
+		return float64(0), err
+	}
+	n, _, err := x.geoip.ASN(ip)
+	return float64(n), err
+}
+
+func (x *ExprEngine) asorg(args ...interface{}) (interface{}, error) {
+	if x.geoip == nil {
+		return nil, errNoGeoIP
+	}
+	ip, err := geoIPArg("asorg", args)
+	if err != nil || ip == nil {
+		return "", err
+	}
+	_, org, err := x.geoip.ASN(ip)
+	return org, err
+}
+
 func toBoolean(v interface{}) (bool, error) {
 	if s, ok := v.(string); ok {
 		return strings.ToLower(s) == "true", nil
@@ -151,9 +224,16 @@ func (r *ruleExpr) Evaluate(data interface{}) (int, error) {
 // required by the interface of Knetic/govaluate for evaluation of the 'variables' in the expression
 // DataRequestExtractor is evaluated first, and if the name does not match then metadata is evaluated
 func (p *dataAsParam) Get(name string) (interface{}, error) {
-	v, exist := p.dataFromReq.Value(name)
-	if exist {
-		return v, nil
+	if p.typedValues {
+		v, exist := p.dataFromReq.TypedValue(name)
+		if exist {
+			return toExprValue(v), nil
+		}
+	} else {
+		v, exist := p.dataFromReq.Value(name)
+		if exist {
+			return v, nil
+		}
 	}
 	f := metadata.ValueFunc(p.ctx, name)
 	if f == nil {
@@ -161,3 +241,24 @@ func (p *dataAsParam) Get(name string) (interface{}, error) {
 	}
 	return f(), nil
 }
+
+// toExprValue convert a typed value of the request into a type that Knetic/govaluate can operate:
+// numbers are float64, IP addresses are strings without brackets, lists are []interface{}
+// and data not available is an empty string
+func toExprValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case int:
+		return float64(v)
+	case net.IP:
+		return v.String()
+	case []string:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = e
+		}
+		return l
+	}
+	return v
+}
@@ -3,6 +3,7 @@ package policy
 import (
 	"context"
 	"fmt"
+	"net"
 	"reflect"
 	"strings"
 	"testing"
@@ -29,7 +30,7 @@ func TestBuildRule(t *testing.T) {
 		{"drop [my/variable / 20", true},
 	}
 	for i, test := range tests {
-		engine := &ExprEngine{TypeDrop, rqdata.NewMapping("-")}
+		engine := &ExprEngine{actionIfErrorEvaluation: TypeDrop, dataFromReq: rqdata.NewMapping("-")}
 		_, err := engine.BuildRule(strings.Split(test.expression, " "))
 		if err != nil {
 			if !test.errorBuild {
@@ -100,7 +101,7 @@ func TestRuleEvaluate(t *testing.T) {
 	}
 	for i, test := range tests {
 
-		engine := &ExprEngine{TypeDrop, rqdata.NewMapping("-")}
+		engine := &ExprEngine{actionIfErrorEvaluation: TypeDrop, dataFromReq: rqdata.NewMapping("-")}
 		rule, err := engine.BuildRule(append([]string{NameTypes[TypeAllow]}, strings.Split(test.expression, " ")...))
 		if err != nil {
 			t.Errorf("Test %d, expr : %s - unexpected error at build rule : %s", i, test.expression, err)
@@ -136,6 +137,72 @@ func TestRuleEvaluate(t *testing.T) {
 	}
 }
 
+func TestRuleEvaluateTypedValues(t *testing.T) {
+	tests := []struct {
+		expression string
+		value      bool
+	}{
+		{"size == 29", true},
+		{"size > 512", false},
+		{"atoi(size) == 29", true},
+		{"port == 40212", true},
+		{"[>do] == false", true},
+		{"client_ip == '10.240.0.1'", true},
+		{"incidr(client_ip,'10.240.0.0/16')", true},
+		{"type == 'HINFO'", true},
+		{"rcode == ''", true},
+	}
+	for i, test := range tests {
+
+		engine := &ExprEngine{actionIfErrorEvaluation: TypeDrop, dataFromReq: rqdata.NewMapping("-")}
+		engine.SetTypedValues(true)
+		rule, err := engine.BuildRule(append([]string{NameTypes[TypeAllow]}, strings.Split(test.expression, " ")...))
+		if err != nil {
+			t.Errorf("Test %d, expr : %s - unexpected error at build rule : %s", i, test.expression, err)
+			continue
+		}
+
+		r := new(dns.Msg)
+		r.SetQuestion("example.org.", dns.TypeHINFO)
+		r.MsgHdr.AuthenticatedData = true
+		state := request.Request{Req: r, W: response.NewReader(&tst.ResponseWriter{})}
+
+		data, err := engine.BuildQueryData(context.TODO(), state)
+		if err != nil {
+			t.Errorf("Test %d, expr : %s - unexpected error at build query data : %s", i, test.expression, err)
+			continue
+		}
+		result, err := rule.Evaluate(data)
+		if err != nil {
+			t.Errorf("Test %d, expr : %s - unexpected error at evaluate  : %s", i, test.expression, err)
+			continue
+		}
+		if (result == TypeAllow) != test.value {
+			t.Errorf("Test %d, expr : %v -  value return is not the one expected - expected : %v, got : %v", i, test.expression, test.value, (result == TypeAllow))
+		}
+	}
+}
+
+func TestToExprValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{nil, ""},
+		{"A", "A"},
+		{512, float64(512)},
+		{true, true},
+		{net.ParseIP("::1"), "::1"},
+		{[]string{"qr", "aa"}, []interface{}{"qr", "aa"}},
+	}
+	for i, test := range tests {
+		v := toExprValue(test.value)
+		if !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("Test %d, value : %#v - expected : %#v, got : %#v", i, test.value, test.expected, v)
+		}
+	}
+}
+
 func TestAtoi(t *testing.T) {
 	tests := []struct {
 		args        []interface{}
@@ -146,6 +213,10 @@ func TestAtoi(t *testing.T) {
 			args:     []interface{}{"42"},
 			expected: float64(42),
 		},
+		{
+			args:     []interface{}{float64(42)},
+			expected: float64(42),
+		},
 		{
 			args:        []interface{}{"42", "100"},
 			expectedErr: fmt.Errorf("atoi requires exactly one string argument"),
@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/policy/plugin/pkg/watch"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPReloadInterval is the period used to check the MaxMind database files for changes
+var GeoIPReloadInterval = 5 * time.Second
+
+// GeoIP holds the local MaxMind databases used by the country/asn/asorg functions of expressions.
+// The databases are reloaded, without interruption of lookups, whenever the files change on disk.
+type GeoIP struct {
+	country *geoDB
+	asn     *geoDB
+}
+
+// geoDB is one MaxMind database file and the watcher of its changes
+type geoDB struct {
+	path  string
+	files *watch.Watcher
+
+	sync.RWMutex
+	reader *maxminddb.Reader
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// NewGeoIP opens the country database and, if the path is not empty, the ASN database
+func NewGeoIP(countryFile, asnFile string) (*GeoIP, error) {
+	g := &GeoIP{}
+	var err error
+	if g.country, err = openGeoDB(countryFile); err != nil {
+		return nil, err
+	}
+	if asnFile != "" {
+		if g.asn, err = openGeoDB(asnFile); err != nil {
+			g.country.close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+func openGeoDB(path string) (*geoDB, error) {
+	// the state of the file is recorded before it is read, so that a change while reading is reloaded
+	db := &geoDB{path: path, files: watch.New(GeoIPReloadInterval, path)}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// load opens the database file, and replaces the previous database only if it succeeds
+func (db *geoDB) load() error {
+	// the database is read in memory rather than mapped, so a file rewritten in place cannot corrupt lookups
+	b, err := ioutil.ReadFile(db.path)
+	if err != nil {
+		return fmt.Errorf("cannot read geoip database %s : %s", db.path, err)
+	}
+	r, err := maxminddb.FromBytes(b)
+	if err != nil {
+		return fmt.Errorf("cannot open geoip database %s : %s", db.path, err)
+	}
+	db.Lock()
+	old := db.reader
+	db.reader = r
+	db.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// reload opens again the database after its file changed
+func (db *geoDB) reload() {
+	if err := db.load(); err != nil {
+		log.Printf("[ERROR] Keeping previous geoip database: %s", err)
+		return
+	}
+	log.Printf("[INFO] Reloaded geoip database %s", db.path)
+}
+
+func (db *geoDB) lookup(ip net.IP, result interface{}) error {
+	db.RLock()
+	defer db.RUnlock()
+	if db.reader == nil {
+		return fmt.Errorf("geoip database %s is closed", db.path)
+	}
+	return db.reader.Lookup(ip, result)
+}
+
+func (db *geoDB) close() {
+	db.files.Stop()
+	db.Lock()
+	defer db.Unlock()
+	if db.reader != nil {
+		db.reader.Close()
+		db.reader = nil
+	}
+}
+
+// Start watches the database files and reload each of them when it changes, until Stop is called
+func (g *GeoIP) Start() {
+	g.country.files.Start(g.country.reload)
+	if g.asn != nil {
+		g.asn.files.Start(g.asn.reload)
+	}
+}
+
+// Stop ends the watch of the database files and close them
+func (g *GeoIP) Stop() {
+	g.country.close()
+	if g.asn != nil {
+		g.asn.close()
+	}
+}
+
+// Country return the ISO code of the country of the IP, or an empty string if unknown
+func (g *GeoIP) Country(ip net.IP) (string, error) {
+	var r countryRecord
+	if err := g.country.lookup(ip, &r); err != nil {
+		return "", err
+	}
+	return r.Country.ISOCode, nil
+}
+
+// ASN return the number and organization of the autonomous system of the IP, or 0 and "" if unknown
+func (g *GeoIP) ASN(ip net.IP) (uint, string, error) {
+	if g.asn == nil {
+		return 0, "", fmt.Errorf("no ASN database is configured")
+	}
+	var r asnRecord
+	if err := g.asn.lookup(ip, &r); err != nil {
+		return 0, "", err
+	}
+	return r.Number, r.Organization, nil
+}
+
+// geoIPArg extract the IP address given as only argument of a geoip function
+// an empty string (e.g. response_ip of a response without address) returns a nil IP and no error
+func geoIPArg(name string, args []interface{}) (net.IP, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s requires exactly one IP address argument", name)
+	}
+	var ip net.IP
+	switch v := args[0].(type) {
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		ip = net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(v, "["), "]"))
+	case net.IP:
+		ip = v
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("%s requires exactly one IP address argument", name)
+	}
+	return ip, nil
+}
@@ -0,0 +1,360 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	tst "github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/pkg/response"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+
+	"github.com/miekg/dns"
+)
+
+// mmdbNode is a node of the search tree of a MaxMind database, each side is either a node, a data or empty
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  [2]interface{}
+}
+
+// writeTestMMDB writes a MaxMind DB (IPv6 tree, 24 bits records) that maps each network to its record
+func writeTestMMDB(t *testing.T, path string, networks map[string]map[string]interface{}) {
+	root := &mmdbNode{}
+	for cidr, record := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := n.Mask.Size()
+		ip := n.IP.To16()
+		if n.IP.To4() != nil {
+			// IPv4 networks are stored in the ::/96 subtree
+			ip = append(make(net.IP, 12), n.IP.To4()...)
+			ones += 96
+		}
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> uint(7-i%8)) & 1
+			if i == ones-1 {
+				node.data[bit] = record
+				break
+			}
+			if node.child[bit] == nil {
+				node.child[bit] = &mmdbNode{}
+			}
+			node = node.child[bit]
+		}
+	}
+
+	// number the nodes in breadth first order, root is 0
+	nodes := []*mmdbNode{root}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].child {
+			if c != nil {
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	index := make(map[*mmdbNode]int, len(nodes))
+	for i, n := range nodes {
+		index[n] = i
+	}
+
+	data := new(bytes.Buffer)
+	tree := new(bytes.Buffer)
+	count := len(nodes)
+	for _, n := range nodes {
+		for side := 0; side < 2; side++ {
+			v := count
+			if n.child[side] != nil {
+				v = index[n.child[side]]
+			} else if n.data[side] != nil {
+				v = count + 16 + data.Len()
+				mmdbEncode(data, n.data[side])
+			}
+			tree.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+
+	out := new(bytes.Buffer)
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+	mmdbEncode(out, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Test",
+		"description":                 map[string]interface{}{"en": "Test database"},
+		"ip_version":                  uint16(6),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+	})
+	if err := ioutil.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mmdbEncode appends the value to the buffer using the MaxMind DB data section format
+func mmdbEncode(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		mmdbControl(b, 2, len(v))
+		b.WriteString(v)
+	case uint16:
+		mmdbUint(b, 5, uint64(v))
+	case uint32:
+		mmdbUint(b, 6, uint64(v))
+	case uint64:
+		mmdbUint(b, 9, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		mmdbControl(b, 7, len(v))
+		for _, k := range keys {
+			mmdbEncode(b, k)
+			mmdbEncode(b, v[k])
+		}
+	case []interface{}:
+		mmdbControl(b, 11, len(v))
+		for _, e := range v {
+			mmdbEncode(b, e)
+		}
+	}
+}
+
+func mmdbUint(b *bytes.Buffer, kind int, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	i := 0
+	for i < 8 && buf[i] == 0 {
+		i++
+	}
+	mmdbControl(b, kind, 8-i)
+	b.Write(buf[i:])
+}
+
+func mmdbControl(b *bytes.Buffer, kind, size int) {
+	var ctrl byte
+	if kind <= 7 {
+		ctrl = byte(kind << 5)
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		extra = []byte{byte(size - 29)}
+	default:
+		ctrl |= 30
+		extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+	}
+	b.WriteByte(ctrl)
+	if kind > 7 {
+		b.WriteByte(byte(kind - 7))
+	}
+	b.Write(extra)
+}
+
+func countryRec(code string) map[string]interface{} {
+	return map[string]interface{}{"country": map[string]interface{}{"iso_code": code}}
+}
+
+func asnRec(n uint32, org string) map[string]interface{} {
+	return map[string]interface{}{"autonomous_system_number": n, "autonomous_system_organization": org}
+}
+
+func TestGeoIPFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	countryFile := filepath.Join(dir, "country.mmdb")
+	asnFile := filepath.Join(dir, "asn.mmdb")
+	writeTestMMDB(t, countryFile, map[string]map[string]interface{}{
+		"1.2.3.0/24":    countryRec("FR"),
+		"2001:db8::/32": countryRec("DE"),
+	})
+	writeTestMMDB(t, asnFile, map[string]map[string]interface{}{
+		"1.2.3.0/24": asnRec(12345, "Example Org"),
+	})
+
+	g, err := NewGeoIP(countryFile, asnFile)
+	if err != nil {
+		t.Fatalf("unexpected error at opening geoip databases : %s", err)
+	}
+	defer g.Stop()
+
+	tests := []struct {
+		expression string
+		value      bool
+		errorExec  bool
+	}{
+		{"country('1.2.3.4') == 'FR'", true, false},
+		{"country('1.2.3.4') IN ('XX', 'FR')", true, false},
+		{"country('[2001:db8::1]') == 'DE'", true, false},
+		{"country('5.6.7.8') == ''", true, false},
+		{"country(response_ip) == ''", true, false},
+		{"asn('1.2.3.4') == 12345", true, false},
+		{"asn('5.6.7.8') == 0", true, false},
+		{"asorg('1.2.3.4') == 'Example Org'", true, false},
+		{"country('invalid') == ''", false, true},
+		{"country('1.2.3.4', '5.6.7.8') == ''", false, true},
+	}
+	for i, test := range tests {
+		engine := &ExprEngine{actionIfErrorEvaluation: TypeDrop, dataFromReq: rqdata.NewMapping(""), geoip: g}
+		rule, err := engine.BuildRule(append([]string{NameTypes[TypeAllow]}, strings.Split(test.expression, " ")...))
+		if err != nil {
+			t.Errorf("Test %d, expr : %s - unexpected error at build rule : %s", i, test.expression, err)
+			continue
+		}
+
+		r := new(dns.Msg)
+		r.SetQuestion("example.org.", dns.TypeA)
+		state := request.Request{Req: r, W: response.NewReader(&tst.ResponseWriter{})}
+
+		data, err := engine.BuildQueryData(context.TODO(), state)
+		if err != nil {
+			t.Errorf("Test %d, expr : %s - unexpected error at build query data : %s", i, test.expression, err)
+			continue
+		}
+		result, err := rule.Evaluate(data)
+		if err != nil {
+			if !test.errorExec {
+				t.Errorf("Test %d, expr : %s - unexpected error at evaluate  : %s", i, test.expression, err)
+			}
+			continue
+		}
+		if test.errorExec {
+			t.Errorf("Test %d, expr : %s - no error at evaluate, when one was expected", i, test.expression)
+			continue
+		}
+		if (result == TypeAllow) != test.value {
+			t.Errorf("Test %d, expr : %v -  value return is not the one expected - expected : %v, got : %v", i, test.expression, test.value, (result == TypeAllow))
+		}
+	}
+}
+
+func TestGeoIPNotConfigured(t *testing.T) {
+	engine := NewExprEngine()
+	for _, f := range []string{"country", "asn", "asorg"} {
+		if _, err := engine.BuildRule([]string{NameTypes[TypeBlock], f + "('1.2.3.4')", "==", "''"}); err == nil {
+			t.Errorf("Function %s - expected an error at build rule when no geoip database is configured", f)
+		}
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	countryFile := filepath.Join(dir, "country.mmdb")
+	writeTestMMDB(t, countryFile, map[string]map[string]interface{}{"1.2.3.0/24": countryRec("FR")})
+
+	g, err := NewGeoIP(countryFile, "")
+	if err != nil {
+		t.Fatalf("unexpected error at opening geoip databases : %s", err)
+	}
+	defer g.Stop()
+
+	if _, _, err := g.ASN(net.ParseIP("1.2.3.4")); err == nil {
+		t.Errorf("expected an error when no ASN database is configured")
+	}
+
+	if g.country.files.Changed() {
+		t.Errorf("expected no change of an unchanged database")
+	}
+
+	writeTestMMDB(t, countryFile, map[string]map[string]interface{}{"1.2.3.0/24": countryRec("IT")})
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(countryFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if !g.country.files.Changed() {
+		t.Fatalf("expected a change of the rewritten database")
+	}
+	if err := g.country.load(); err != nil {
+		t.Fatalf("unexpected error at reload of the changed database : %s", err)
+	}
+	if c, err := g.Country(net.ParseIP("1.2.3.4")); err != nil || c != "IT" {
+		t.Errorf("expected country IT after reload, got %q, error : %v", c, err)
+	}
+
+	// a broken database keeps the previous one active
+	if err := ioutil.WriteFile(countryFile, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.country.load(); err == nil {
+		t.Errorf("expected an error at reload of an invalid database")
+	}
+	if c, err := g.Country(net.ParseIP("1.2.3.4")); err != nil || c != "IT" {
+		t.Errorf("expected country IT to be kept after failed reload, got %q, error : %v", c, err)
+	}
+}
+
+func TestGeoIPReloadChangedDatabase(t *testing.T) {
+	defer func(interval time.Duration) { GeoIPReloadInterval = interval }(GeoIPReloadInterval)
+	GeoIPReloadInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	countryFile := filepath.Join(dir, "country.mmdb")
+	writeTestMMDB(t, countryFile, map[string]map[string]interface{}{"1.2.3.0/24": countryRec("FR")})
+	asnFile := filepath.Join(dir, "asn.mmdb")
+	writeTestMMDB(t, asnFile, map[string]map[string]interface{}{"1.2.3.0/24": asnRec(12345, "Example Org")})
+
+	g, err := NewGeoIP(countryFile, asnFile)
+	if err != nil {
+		t.Fatalf("unexpected error at opening geoip databases : %s", err)
+	}
+	asnReader := g.asn.reader
+	g.Start()
+	defer g.Stop()
+
+	writeTestMMDB(t, countryFile, map[string]map[string]interface{}{"1.2.3.0/24": countryRec("IT")})
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(countryFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if c, _ := g.Country(net.ParseIP("1.2.3.4")); c == "IT" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the changed country database was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	g.asn.RLock()
+	defer g.asn.RUnlock()
+	if g.asn.reader != asnReader {
+		t.Errorf("expected the unchanged ASN database not to be reloaded")
+	}
+}
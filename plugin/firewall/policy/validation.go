@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+
+	expr "github.com/Knetic/govaluate"
+)
+
+const (
+	// ValidationOff disables the validation of the variables of expressions
+	ValidationOff = iota
+	// ValidationWarn logs a warning for each invalid variable of expressions
+	ValidationWarn
+	// ValidationStrict fails the build of rules with invalid variables
+	ValidationStrict
+)
+
+// NameValidations keep a mapping of the validation modes to the corresponding name
+var NameValidations = map[int]string{
+	ValidationOff:    "off",
+	ValidationWarn:   "warn",
+	ValidationStrict: "strict",
+}
+
+// kind of the operands of a comparison that cannot be determined
+const kindUnknown = ""
+
+// SetValidation defines how the variables of the expressions are validated when building the rules
+func (x *ExprEngine) SetValidation(mode int) {
+	x.validation = mode
+}
+
+// AddMetadataLabels declares metadata labels that can be used as variables in expressions.
+// A label can be a pattern as defined by path.Match, e.g. 'kubernetes/*'
+func (x *ExprEngine) AddMetadataLabels(labels ...string) error {
+	for _, l := range labels {
+		if _, err := path.Match(l, ""); err != nil {
+			return fmt.Errorf("invalid metadata label %s : %s", l, err)
+		}
+	}
+	x.metadata = append(x.metadata, labels...)
+	return nil
+}
+
+// validate checks that the variables of the expression are either data of the request or declared metadata labels,
+// and that they are not compared to a literal of another type.
+// Depending on the validation mode, the problems found are logged or returned as an error
+func (x *ExprEngine) validate(e *expr.EvaluableExpression) error {
+	if x.validation == ValidationOff {
+		return nil
+	}
+	var problems []string
+	tokens := e.Tokens()
+	for _, t := range tokens {
+		if t.Kind != expr.VARIABLE {
+			continue
+		}
+		name := t.Value.(string)
+		if _, ok := x.variableKind(name); !ok {
+			problems = append(problems, fmt.Sprintf("unknown variable '%s', neither a data of the request nor a declared metadata label", name))
+		}
+	}
+	for i, t := range tokens {
+		if t.Kind != expr.COMPARATOR || i == 0 || i == len(tokens)-1 {
+			continue
+		}
+		left, right := x.operandKind(tokens, i-1, -1), x.operandKind(tokens, i+1, 1)
+		op := t.Value.(string)
+		switch {
+		case strings.EqualFold(op, "in"):
+			// the right operand is a list of values
+			continue
+		case op == "=~" || op == "!~":
+			if left != kindUnknown && left != rqdata.KindString {
+				problems = append(problems, fmt.Sprintf("operator %s applies to a %s, expected a string", op, left))
+			}
+			continue
+		case left == kindUnknown || right == kindUnknown:
+			continue
+		}
+		if left != right {
+			problems = append(problems, fmt.Sprintf("operator %s compares a %s with a %s%s", op, left, right, conversionHint(tokens, i, left, right)))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if x.validation == ValidationStrict {
+		return fmt.Errorf("invalid expression '%s' : %s", e.String(), strings.Join(problems, ", "))
+	}
+	for _, p := range problems {
+		log.Printf("[WARN] Expression '%s' : %s", e.String(), p)
+	}
+	return nil
+}
+
+// variableKind return the kind of value of the variable as provided to expressions,
+// or false if the variable is unknown
+func (x *ExprEngine) variableKind(name string) (string, bool) {
+	if k, ok := x.dataFromReq.Kind(name); ok {
+		if !x.typedValues {
+			return rqdata.KindString, true
+		}
+		if k == rqdata.KindAddress {
+			// addresses are provided as strings, see toExprValue
+			return rqdata.KindString, true
+		}
+		return k, true
+	}
+	for _, l := range x.metadata {
+		if ok, _ := path.Match(l, name); ok {
+			return rqdata.KindString, true
+		}
+	}
+	return kindUnknown, false
+}
+
+// operandKind return the kind of the operand at position i, if that operand is a single variable or literal.
+// dir is the direction away from the comparator, used to ensure the operand is not part of a larger sub-expression
+func (x *ExprEngine) operandKind(tokens []expr.ExpressionToken, i int, dir int) string {
+	if n := i + dir; n >= 0 && n < len(tokens) {
+		switch tokens[n].Kind {
+		case expr.MODIFIER, expr.PREFIX, expr.FUNCTION, expr.ACCESSOR:
+			return kindUnknown
+		}
+	}
+	switch tokens[i].Kind {
+	case expr.VARIABLE:
+		k, _ := x.variableKind(tokens[i].Value.(string))
+		return k
+	case expr.NUMERIC:
+		return rqdata.KindNumber
+	case expr.STRING:
+		return rqdata.KindString
+	case expr.BOOLEAN:
+		return rqdata.KindBoolean
+	}
+	return kindUnknown
+}
+
+// conversionHint suggest the use of atoi when a string variable is compared with a number
+func conversionHint(tokens []expr.ExpressionToken, i int, left, right string) string {
+	v := tokens[i-1]
+	if left != rqdata.KindString || right != rqdata.KindNumber {
+		v = tokens[i+1]
+		if right != rqdata.KindString || left != rqdata.KindNumber {
+			return ""
+		}
+	}
+	if v.Kind != expr.VARIABLE {
+		return ""
+	}
+	return fmt.Sprintf(", use atoi(%s)", v.Value)
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+)
+
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		expression string
+		typed      bool
+		errorBuild bool
+	}{
+		{"true", false, false},
+		{"client_ip == '10.0.0.1'", false, false},
+		{"clent_ip == '10.0.0.1'", false, true},
+		{"[kubernetes/namespace] == 'default'", false, false},
+		{"[kubernetes/client-namespace] == 'default'", false, false},
+		{"[mac/address] =~ '.*:FF:.*'", false, true},
+		{"[edns/client_id] == 'ABCDEF'", false, false},
+		{"size > 512", false, true},
+		{"size == 512", false, true},
+		{"512 < size", false, true},
+		{"atoi(size) > 512", false, false},
+		{"size + 1 > 512", false, false},
+		{"name =~ 'example.org'", false, false},
+		{"size > 512", true, false},
+		{"size == '512'", true, true},
+		{"size =~ '5.*'", true, true},
+		{"[>do] == true", true, false},
+		{"[>do] == 'true'", true, true},
+		{"client_ip == '::1'", true, false},
+		{"'aa' IN [>rflags]", true, false},
+	}
+	for i, test := range tests {
+		engine := &ExprEngine{actionIfErrorEvaluation: TypeDrop, dataFromReq: rqdata.NewMapping(""), validation: ValidationStrict}
+		engine.SetTypedValues(test.typed)
+		if err := engine.AddMetadataLabels("kubernetes/*", "edns/client_id"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := engine.BuildRule(append([]string{NameTypes[TypeAllow]}, strings.Split(test.expression, " ")...))
+		if err != nil {
+			if !test.errorBuild {
+				t.Errorf("Test %d, expr : %s - unexpected error at build rule : %s", i, test.expression, err)
+			}
+			continue
+		}
+		if test.errorBuild {
+			t.Errorf("Test %d, expr : %s - no error at build rule, when one was expected", i, test.expression)
+		}
+	}
+}
+
+func TestValidationModes(t *testing.T) {
+	for _, mode := range []int{ValidationOff, ValidationWarn} {
+		engine := &ExprEngine{actionIfErrorEvaluation: TypeDrop, dataFromReq: rqdata.NewMapping(""), validation: mode}
+		if _, err := engine.BuildRule([]string{NameTypes[TypeAllow], "clent_ip", "==", "'10.0.0.1'"}); err != nil {
+			t.Errorf("Mode %s : unexpected error at build rule : %s", NameValidations[mode], err)
+		}
+	}
+	engine := NewExprEngine()
+	if err := engine.AddMetadataLabels("kubernetes/[a-"); err == nil {
+		t.Errorf("expected an error for an invalid metadata label pattern")
+	}
+}
+
+func TestConversionHint(t *testing.T) {
+	engine := &ExprEngine{actionIfErrorEvaluation: TypeDrop, dataFromReq: rqdata.NewMapping(""), validation: ValidationStrict}
+	_, err := engine.BuildRule([]string{NameTypes[TypeAllow], "size", ">", "512"})
+	if err == nil || !strings.Contains(err.Error(), "use atoi(size)") {
+		t.Errorf("expected an error suggesting the use of atoi, got %v", err)
+	}
+}
@@ -106,32 +106,58 @@ func (p *List) buildReplyData(ctx context.Context, name string, state request.Re
 //Evaluate all policy one by one until one provide a valid result
 //if no Rule can provide a result, the DefaultPolicy of the list applies
 func (p *List) Evaluate(ctx context.Context, state request.Request, data map[string]interface{}, engines map[string]policy.Engine) (int, error) {
+	d, err := p.Decide(ctx, state, data, engines)
+	if err != nil {
+		return policy.TypeNone, err
+	}
+	return d.Action, nil
+}
+
+//Decide evaluates all policy one by one until one provide a valid result, and return the Decision of that Rule
+//if no Rule can provide a result, the DefaultPolicy of the list applies
+func (p *List) Decide(ctx context.Context, state request.Request, data map[string]interface{}, engines map[string]policy.Engine) (*policy.Decision, error) {
 	var dataReply = make(map[string]interface{}, 0)
 	for i, r := range p.Rules {
 		rd, err := p.buildQueryData(ctx, r.Name, state, data, engines)
 		if err != nil {
-			return policy.TypeNone, fmt.Errorf("rulelist Rule %v, with Name %s - cannot build query data for evaluation %s", i, r.Name, err)
+			return nil, fmt.Errorf("rulelist Rule %v, with Name %s - cannot build query data for evaluation %s", i, r.Name, err)
 		}
 		if p.Reply {
 			rd, err = p.buildReplyData(ctx, r.Name, state, rd, dataReply, engines)
 			if err != nil {
-				return policy.TypeNone, fmt.Errorf("rulelist Rule %v, with Name %s - cannot build Reply data for evaluation %s", i, r.Name, err)
+				return nil, fmt.Errorf("rulelist Rule %v, with Name %s - cannot build Reply data for evaluation %s", i, r.Name, err)
 			}
 		}
-		pr, err := r.Rule.Evaluate(rd)
+		d, err := decide(r.Rule, rd)
 		if err != nil {
-			return policy.TypeNone, fmt.Errorf("rulelist Rule %v returned an error at evaluation %s", i, err)
+			return nil, fmt.Errorf("rulelist Rule %v returned an error at evaluation %s", i, err)
 		}
-		if pr >= policy.TypeCount {
-			return policy.TypeNone, fmt.Errorf("rulelist Rule %v returned an invalid value %v", i, pr)
+		if d.Action >= policy.TypeCount {
+			return nil, fmt.Errorf("rulelist Rule %v returned an invalid value %v", i, d.Action)
 
 		}
-		if pr != policy.TypeNone {
+		if d.Action != policy.TypeNone {
 			// Rule returned a valid value
-			return pr, nil
+			return d, nil
 		}
 		// if no result just continue on next Rule
 	}
 	// if none of Rule make a statement, then we return the default policy
-	return p.DefaultPolicy, nil
+	return &policy.Decision{Action: p.DefaultPolicy}, nil
+}
+
+// decide return the Decision of the Rule, or a Decision of the action it evaluates to if it is not a Decider
+func decide(r policy.Rule, data interface{}) (*policy.Decision, error) {
+	if dr, ok := r.(policy.Decider); ok {
+		d, err := dr.Decide(data)
+		if d == nil && err == nil {
+			d = &policy.Decision{Action: policy.TypeNone}
+		}
+		return d, err
+	}
+	action, err := r.Evaluate(data)
+	if err != nil {
+		return nil, err
+	}
+	return &policy.Decision{Action: action}, nil
 }
@@ -30,6 +30,17 @@ func setup(c *caddy.Controller) error {
 		return fw
 	})
 
+	if g := fw.exprEngine().GeoIP(); g != nil {
+		c.OnStartup(func() error {
+			g.Start()
+			return nil
+		})
+		c.OnShutdown(func() error {
+			g.Stop()
+			return nil
+		})
+	}
+
 	c.OnStartup(func() error {
 		// after all plugin are setup, ensure to have all rules created by enrolling the engines pointed
 		// by pending rules
@@ -77,19 +88,89 @@ func parse(c *caddy.Controller) (*firewall, error) {
 			if err != nil {
 				return nil, err
 			}
+			if r == nil {
+				// an option of the firewall, not a rule
+				continue
+			}
 			err = rl.Add(r)
 			if err != nil {
 				return nil, c.Errf("cannot add a rule to the %s list : %s", location, err)
 			}
 		}
 	}
+
+	// now that all the options of the Expression engine are known, build the expression rules
+	e := p.exprEngine()
+	for _, rl := range []*rule.List{p.query, p.reply} {
+		for _, r := range rl.Rules {
+			if r.Name != ExpressionEngineName {
+				continue
+			}
+			if r.Rule, err = e.BuildRule(r.Params); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return p, nil
 }
 
 func (p *firewall) parseOptionOrRule(c *caddy.Controller) (*rule.Element, error) {
 	// by default, at least one engine is available : the ExpressionEngine
-	e := policy.NewExprEngine()
+	e := p.exprEngine()
 	switch c.Val() {
+	case "geoip":
+		// geoip COUNTRY_DB [ASN_DB] : MaxMind databases used by the functions country, asn and asorg of expressions
+		args := c.RemainingArgs()
+		if len(args) < 1 || len(args) > 2 {
+			return nil, c.Errf("geoip expects the country database and optionally the ASN database, got %s", strings.Join(args, " "))
+		}
+		if e.GeoIP() != nil {
+			return nil, c.Errf("geoip databases are already defined")
+		}
+		asnFile := ""
+		if len(args) > 1 {
+			asnFile = args[1]
+		}
+		g, err := policy.NewGeoIP(args[0], asnFile)
+		if err != nil {
+			return nil, c.Err(err.Error())
+		}
+		e.SetGeoIP(g)
+		return nil, nil
+
+	case "metadata":
+		// metadata LABEL [LABEL...] : metadata labels that can be used as variables of expressions
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return nil, c.ArgErr()
+		}
+		if err := e.AddMetadataLabels(args...); err != nil {
+			return nil, c.Err(err.Error())
+		}
+		return nil, nil
+
+	case "validation":
+		// validation off|warn|strict : how unknown variables and type mismatches of expressions are reported
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		for mode, name := range policy.NameValidations {
+			if args[0] == name {
+				e.SetValidation(mode)
+				return nil, nil
+			}
+		}
+		return nil, c.Errf("invalid validation mode %s, expected off, warn or strict", args[0])
+
+	case "typed_values":
+		// provide the data of the request to expressions as numbers, booleans and lists instead of strings
+		if args := c.RemainingArgs(); len(args) != 0 {
+			return nil, c.ArgErr()
+		}
+		e.SetTypedValues(true)
+		return nil, nil
+
 	case policy.NameTypes[policy.TypeRefuse]:
 		fallthrough
 	case policy.NameTypes[policy.TypeAllow]:
@@ -105,11 +186,8 @@ func (p *firewall) parseOptionOrRule(c *caddy.Controller) (*rule.Element, error)
 			return nil, fmt.Errorf("not enough arguments to build a policy rule, expect allow/refuse/block/drop query/reply <expression>, got %s %s", c.Val(), strings.Join(args, " "))
 		}
 		params := append([]string{action}, args...)
-		r, err := e.BuildRule(params)
-		if err != nil {
-			return nil, err
-		}
-		return &rule.Element{"", name, params, r}, nil
+		// the Rule is built at end of parsing, when all options of the Expression engine are known
+		return &rule.Element{Plugin: "", Name: name, Params: params}, nil
 
 	default:
 		// we can only suppose it is an engine type(plugin name), name and args
@@ -121,7 +199,7 @@ func (p *firewall) parseOptionOrRule(c *caddy.Controller) (*rule.Element, error)
 		name := args[0]
 		params := args[1:]
 		// as the Engine are not yet knowm, just create a ruleElement with the parameters.The Element will be created later
-		return &rule.Element{plugin, name, params, nil}, nil
+		return &rule.Element{Plugin: plugin, Name: name, Params: params}, nil
 
 	}
 }
@@ -53,6 +53,46 @@ func TestSetup(t *testing.T) {
 		{`firewall query {
  				name-of-plugin-error-if-no-policy-name
 			}`, true, 1, 0},
+		{`firewall query {
+				typed_values
+				allow size > 512
+			}`, false, 1, 0},
+		{`firewall query {
+				typed_values yes
+			}`, true, 0, 0},
+		{`firewall query {
+				validation strict
+				allow clent_ip == '10.0.0.1'
+			}`, true, 0, 0},
+		{`firewall query {
+				allow [kubernetes/namespace] == 'default'
+				validation strict
+				metadata kubernetes/*
+			}`, false, 1, 0},
+		{`firewall query {
+				validation strict
+				allow size > 512
+			}`, true, 0, 0},
+		{`firewall query {
+				validation warn
+				allow size > 512
+			}`, false, 1, 0},
+		{`firewall query {
+				validation never
+			}`, true, 0, 0},
+		{`firewall query {
+				metadata
+			}`, true, 0, 0},
+		{`firewall query {
+				geoip
+			}`, true, 0, 0},
+		{`firewall query {
+				geoip country.mmdb asn.mmdb other.mmdb
+			}`, true, 0, 0},
+		{`firewall query {
+				geoip /non/existing/country.mmdb
+				allow true
+			}`, true, 0, 0},
 	}
 	for i, test := range tests {
 		c := caddy.NewTestController("dns", test.input)
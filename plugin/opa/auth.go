@@ -0,0 +1,83 @@
+package opa
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/coredns/policy/plugin/pkg/watch"
+)
+
+// unixScheme is the scheme of the endpoints that are Unix sockets, e.g. unix:///var/run/opa.sock
+const unixScheme = "unix://"
+
+// unixHost is the base URL of the requests sent to a Unix socket
+const unixHost = "http://unix"
+
+// tokenFile is a bearer token read from a file. The file is read again whenever it changes on disk
+type tokenFile struct {
+	path  string
+	files *watch.Watcher
+	token atomic.Value
+}
+
+func newTokenFile(path string) (*tokenFile, error) {
+	// watched before the first read, so that a token rotated meanwhile is read again
+	t := &tokenFile{path: path, files: watch.New(ReloadInterval, path)}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load reads the token, and replaces the previous token only if it succeeds
+func (t *tokenFile) load() error {
+	b, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return fmt.Errorf("cannot read bearer token %s : %s", t.path, err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return fmt.Errorf("empty bearer token in %s", t.path)
+	}
+	t.token.Store(token)
+	return nil
+}
+
+func (t *tokenFile) reload() {
+	if err := t.load(); err != nil {
+		log.Printf("[ERROR] Keeping previous OPA bearer token: %s", err)
+		return
+	}
+	log.Printf("[INFO] Reloaded OPA bearer token from %s", t.path)
+}
+
+// start watches the token file and reload it on change, until stop is called
+func (t *tokenFile) start() { t.files.Start(t.reload) }
+
+func (t *tokenFile) stop() { t.files.Stop() }
+
+func (t *tokenFile) value() string { return t.token.Load().(string) }
+
+// setHeaders adds the static headers and the bearer token to a request to the OPA server
+func (e *engine) setHeaders(req *http.Request) {
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	if e.token != nil {
+		req.Header.Set("Authorization", "Bearer "+e.token.value())
+	}
+}
+
+// dialUnix return a dial function that connects to the Unix socket, whatever the address requested
+func dialUnix(socket string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+}
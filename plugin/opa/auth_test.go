@@ -0,0 +1,104 @@
+package opa
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/policy/plugin/firewall/policy"
+)
+
+func TestHeaders(t *testing.T) {
+	// the server allows only the requests with the expected headers
+	token := "token1"
+	apiStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token || r.Header.Get("X-Tenant") != "dns" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("{\"result\":\"allow\"}"))
+	}))
+	defer apiStub.Close()
+
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := writeTestFile(t, dir, "token", "token1\n")
+
+	o, err := parse(caddy.NewTestController("dns", `opa myengine {
+                 endpoint `+apiStub.URL+`
+                 header X-Tenant dns
+                 bearer_token_file `+tokenFile+`
+               }`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["myengine"]
+	if result, err := e.Evaluate(input{"name": "example.org."}); err != nil || result != policy.TypeAllow {
+		t.Errorf("expected allow, got %s, error : %v", policy.NameTypes[result], err)
+	}
+
+	// the new token is used after a reload of the file
+	token = "token2"
+	if _, err := e.Evaluate(input{"name": "example.org."}); err == nil {
+		t.Errorf("expected an error with the previous token")
+	}
+	writeTestFile(t, dir, "token", "token2")
+	e.token.reload()
+	if result, err := e.Evaluate(input{"name": "example.org."}); err != nil || result != policy.TypeAllow {
+		t.Errorf("expected allow, got %s, error : %v", policy.NameTypes[result], err)
+	}
+
+	// the previous token is kept if the file is invalid
+	writeTestFile(t, dir, "token", "")
+	e.token.reload()
+	if e.token.value() != "token2" {
+		t.Errorf("expected the previous token to be kept, got %s", e.token.value())
+	}
+}
+
+func TestUnixEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "opa.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiStub := &httptest.Server{
+		Listener: l,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/data/dns/action" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("{\"result\":\"block\"}"))
+		})},
+	}
+	apiStub.Start()
+	defer apiStub.Close()
+
+	o, err := parse(caddy.NewTestController("dns", "opa myengine {\nendpoint unix://"+socket+" /v1/data/dns\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := o.engines["myengine"].BuildRule([]string{"action"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := r.Evaluate(input{"name": "example.org."}); err != nil || result != policy.TypeBlock {
+		t.Errorf("expected block, got %s, error : %v", policy.NameTypes[result], err)
+	}
+}
@@ -0,0 +1,88 @@
+package opa
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// load balancing policies of the endpoints
+const (
+	balancePriority   = "priority"
+	balanceRoundRobin = "round_robin"
+)
+
+// endpoint is an OPA server. After consecutive failures, it is ejected: it receives requests only when no other
+// endpoint is available, until the end of a backoff. The next request after the backoff probes the endpoint,
+// which is ejected again if it fails
+type endpoint struct {
+	url    string // base URL of the policies
+	socket string // if set, the Unix socket of the server
+	client *http.Client
+
+	fails   uint32 // number of consecutive failures
+	ejected int64  // end of the ejection, in unix nanoseconds
+}
+
+// name return the URL of the endpoint, as written in the configuration
+func (u *endpoint) name() string {
+	if u.socket != "" {
+		return unixScheme + u.socket + " " + strings.TrimPrefix(u.url, unixHost)
+	}
+	return u.url
+}
+
+// policyURL return the URL of the policy at the path, relative to the URL of the endpoint
+func (u *endpoint) policyURL(path string) string {
+	if path == "" {
+		return u.url
+	}
+	return strings.TrimSuffix(u.url, "/") + "/" + path
+}
+
+func (u *endpoint) healthy(now time.Time) bool { return atomic.LoadInt64(&u.ejected) <= now.UnixNano() }
+
+// candidates return the endpoints in the order they are tried: by priority, or starting with the next endpoint in
+// round robin. The ejected endpoints are tried last. healthy is the number of endpoints not ejected
+func (e *engine) candidates() (l []*endpoint, healthy int) {
+	n := len(e.endpoints)
+	start := 0
+	if e.balance == balanceRoundRobin && n > 1 {
+		start = int(atomic.AddUint32(&e.next, 1) % uint32(n))
+	}
+	l = make([]*endpoint, 0, n)
+	var ejected []*endpoint
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		u := e.endpoints[(start+i)%n]
+		if u.healthy(now) {
+			l = append(l, u)
+			continue
+		}
+		ejected = append(ejected, u)
+	}
+	return append(l, ejected...), len(l)
+}
+
+// report records the outcome of a request to the endpoint, and ejects the endpoint after maxFails consecutive failures
+func (e *engine) report(u *endpoint, failed bool) {
+	endpointRequests.WithLabelValues(e.name, u.name()).Inc()
+	if !failed {
+		if atomic.SwapUint32(&u.fails, 0) >= uint32(e.maxFails) && e.maxFails > 0 {
+			log.Printf("[INFO] OPA endpoint %s of engine %s is available again", u.name(), e.name)
+			endpointHealthy.WithLabelValues(e.name, u.name()).Set(1)
+		}
+		return
+	}
+	endpointFailures.WithLabelValues(e.name, u.name()).Inc()
+	if e.maxFails == 0 || atomic.AddUint32(&u.fails, 1) < uint32(e.maxFails) {
+		return
+	}
+	log.Printf("[WARN] OPA endpoint %s of engine %s is ejected for %s after %d consecutive failures",
+		u.name(), e.name, e.failTimeout, atomic.LoadUint32(&u.fails))
+	atomic.StoreInt64(&u.ejected, time.Now().Add(e.failTimeout).UnixNano())
+	endpointEjections.WithLabelValues(e.name, u.name()).Inc()
+	endpointHealthy.WithLabelValues(e.name, u.name()).Set(0)
+}
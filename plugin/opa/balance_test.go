@@ -0,0 +1,132 @@
+package opa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/policy/plugin/firewall/policy"
+)
+
+// testEndpoint is an OPA server that counts its requests, and fails them while down is set
+type testEndpoint struct {
+	*httptest.Server
+	requests int32
+	down     int32
+}
+
+func newTestEndpoint(t *testing.T) *testEndpoint {
+	u := &testEndpoint{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&u.requests, 1)
+		if atomic.LoadInt32(&u.down) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("{\"result\":\"allow\"}"))
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// count return the number of requests since the last call
+func (u *testEndpoint) count() int32 { return atomic.SwapInt32(&u.requests, 0) }
+
+func TestBalancePriority(t *testing.T) {
+	primary, secondary := newTestEndpoint(t), newTestEndpoint(t)
+	o, err := parse(caddy.NewTestController("dns", `opa myengine {
+                 endpoint `+primary.URL+`
+                 endpoint `+secondary.URL+`
+                 max_fails 2
+                 fail_timeout 100ms
+               }`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["myengine"]
+	evaluate := func(n int) {
+		for i := 0; i < n; i++ {
+			if result, err := e.Evaluate(input{"name": "example.org."}); err != nil || result != policy.TypeAllow {
+				t.Fatalf("expected allow, got %s, error : %v", policy.NameTypes[result], err)
+			}
+		}
+	}
+
+	// all the requests go to the primary endpoint
+	evaluate(5)
+	if p, s := primary.count(), secondary.count(); p != 5 || s != 0 {
+		t.Errorf("expected 5 requests to the primary and 0 to the secondary, got %d and %d", p, s)
+	}
+
+	// a failed request is sent to the secondary, and the primary is ejected after 2 failures
+	atomic.StoreInt32(&primary.down, 1)
+	evaluate(5)
+	if p, s := primary.count(), secondary.count(); p != 2 || s != 5 {
+		t.Errorf("expected 2 requests to the primary and 5 to the secondary, got %d and %d", p, s)
+	}
+
+	// after the backoff, a request probes the primary, which is ejected again if it still fails
+	time.Sleep(150 * time.Millisecond)
+	evaluate(3)
+	if p, s := primary.count(), secondary.count(); p != 1 || s != 3 {
+		t.Errorf("expected 1 request to the primary and 3 to the secondary, got %d and %d", p, s)
+	}
+
+	// the primary receives the requests again when it recovers
+	atomic.StoreInt32(&primary.down, 0)
+	time.Sleep(150 * time.Millisecond)
+	evaluate(3)
+	if p, s := primary.count(), secondary.count(); p != 3 || s != 0 {
+		t.Errorf("expected 3 requests to the primary and 0 to the secondary, got %d and %d", p, s)
+	}
+
+	// when all endpoints fail, the request fails
+	atomic.StoreInt32(&primary.down, 1)
+	atomic.StoreInt32(&secondary.down, 1)
+	if _, err := e.Evaluate(input{"name": "example.org."}); err == nil {
+		t.Errorf("expected an error when all the endpoints fail")
+	}
+}
+
+func TestBalanceRoundRobin(t *testing.T) {
+	endpoints := []*testEndpoint{newTestEndpoint(t), newTestEndpoint(t), newTestEndpoint(t)}
+	o, err := parse(caddy.NewTestController("dns", `opa myengine {
+                 endpoint `+endpoints[0].URL+`
+                 endpoint `+endpoints[1].URL+`
+                 endpoint `+endpoints[2].URL+`
+                 balance round_robin
+               }`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["myengine"]
+	for i := 0; i < 9; i++ {
+		if _, err := e.Evaluate(input{"name": "example.org."}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, u := range endpoints {
+		if n := u.count(); n != 3 {
+			t.Errorf("expected 3 requests to endpoint %d, got %d", i, n)
+		}
+	}
+
+	// the requests of an ejected endpoint go to the others
+	atomic.StoreInt32(&endpoints[1].down, 1)
+	for i := 0; i < 12; i++ {
+		if _, err := e.Evaluate(input{"name": "example.org."}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := endpoints[1].count(); n != 3 {
+		t.Errorf("expected 3 requests to the failing endpoint before its ejection, got %d", n)
+	}
+	if n := endpoints[0].count() + endpoints[2].count(); n != 12 {
+		t.Errorf("expected 12 requests to the other endpoints, got %d", n)
+	}
+}
@@ -0,0 +1,134 @@
+package opa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+// defaultBundleInterval is the default period of the downloads of a bundle
+const defaultBundleInterval = time.Minute
+
+// bundleSource downloads the policies and data of a local engine from a bundle server. The bundle is downloaded
+// periodically, and activated only if it changed, its signature is valid and its policies compile
+type bundleSource struct {
+	url          string
+	interval     time.Duration
+	client       *http.Client
+	verification *bundle.VerificationConfig // the bundle must be signed by one of the keys
+
+	etag   string         // ETag of the active bundle
+	active *bundle.Bundle // nil until the first bundle is activated
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// errNotModified is returned by download when the bundle did not change since the last download
+var errNotModified = errors.New("bundle not modified")
+
+func newBundleSource(url string, interval time.Duration) *bundleSource {
+	return &bundleSource{url: url, interval: interval, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// download return the bundle of the server and its ETag, or errNotModified if its ETag did not change. The
+// signature of the bundle is verified with the configured keys
+func (s *bundleSource) download() (*bundle.Bundle, string, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, "", errNotModified
+	default:
+		return nil, "", fmt.Errorf("bundle server returned status %s", resp.Status)
+	}
+
+	b, err := bundle.NewReader(resp.Body).WithBundleVerificationConfig(s.verification).Read()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(b.Signatures.Signatures) == 0 {
+		return nil, "", errors.New("bundle is not signed")
+	}
+	return &b, resp.Header.Get("ETag"), nil
+}
+
+// update downloads the bundle, and activates it if it changed. The previous bundle remains active if the new
+// bundle cannot be activated
+func (l *local) update() error {
+	s := l.bundle
+	b, etag, err := s.download()
+	if err == errNotModified {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot download bundle %s : %s", s.url, err)
+	}
+
+	l.loading.Lock()
+	defer l.loading.Unlock()
+	previous := s.active
+	s.active = b
+	if err := l.load(); err != nil {
+		s.active = previous
+		return err
+	}
+	s.etag = etag
+	log.Printf("[INFO] Activated OPA bundle %s, revision '%s'", s.url, b.Manifest.Revision)
+	return nil
+}
+
+// startBundle downloads the bundle now and periodically, until stopBundle is called
+func (l *local) startBundle() {
+	s := l.bundle
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := l.update(); err != nil {
+				log.Printf("[ERROR] Keeping previous OPA bundle: %s", err)
+			}
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (l *local) stopBundle() {
+	s := l.bundle
+	if s.stop != nil {
+		close(s.stop)
+		s.wg.Wait()
+		s.stop = nil
+	}
+}
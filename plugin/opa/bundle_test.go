@@ -0,0 +1,175 @@
+package opa
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+// testBundle return a bundle with a policy returning the action for the blocked names, signed with the secret if any
+func testBundle(t *testing.T, revision, action, secret string) []byte {
+	b := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: revision},
+		Modules: []bundle.ModuleFile{{
+			URL:  "/dns.rego",
+			Path: "/dns.rego",
+			Raw:  []byte("package dns\n\naction = \"" + action + "\" { input.name == data.blocked[_] }\n"),
+		}},
+		Data: map[string]interface{}{"blocked": []interface{}{"example.org."}},
+	}
+	b.Manifest.Init()
+	if secret != "" {
+		if err := b.GenerateSignature(bundle.NewSigningConfig(secret, "HS256", ""), "testkey", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := bundle.NewWriter(&buf).Write(b); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// bundleServer serves a bundle with its revision as ETag
+type bundleServer struct {
+	sync.Mutex
+	etag      string
+	data      []byte
+	downloads int
+}
+
+func (s *bundleServer) set(etag string, data []byte) {
+	s.Lock()
+	s.etag, s.data = etag, data
+	s.Unlock()
+}
+
+func (s *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if r.Header.Get("If-None-Match") == s.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.downloads++
+	w.Header().Set("ETag", s.etag)
+	w.Write(s.data)
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := writeTestFile(t, dir, "key", "secret")
+
+	bs := &bundleServer{}
+	server := httptest.NewServer(bs)
+	defer server.Close()
+
+	o, err := parse(caddy.NewTestController("dns", `opa myengine {
+                 bundle `+server.URL+`/bundles/dns.tar.gz 1h
+                 bundle_key testkey `+keyFile+` HS256
+                 query data.dns.action
+               }`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["myengine"]
+	l := e.local
+	r, err := e.BuildRule([]string{"dns/action"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the policy cannot be evaluated until a bundle is activated
+	if _, err := e.Evaluate(input{"name": "example.org."}); err == nil {
+		t.Errorf("expected an error before the activation of a bundle")
+	}
+
+	tests := []struct {
+		etag      string
+		data      []byte
+		downloads int
+		expected  int
+		shouldErr bool
+	}{
+		{"1", testBundle(t, "1", "block", "secret"), 1, policy.TypeBlock, false},
+		// the bundle is not downloaded again if its ETag did not change
+		{"1", testBundle(t, "1", "block", "secret"), 1, policy.TypeBlock, false},
+		// the previous bundle remains active if the signature is invalid, or missing
+		{"2", testBundle(t, "2", "refuse", "other"), 2, policy.TypeBlock, true},
+		{"3", testBundle(t, "3", "refuse", ""), 3, policy.TypeBlock, true},
+		{"4", []byte("invalid"), 4, policy.TypeBlock, true},
+		{"5", testBundle(t, "5", "refuse", "secret"), 5, policy.TypeRefuse, false},
+	}
+	for i, tc := range tests {
+		bs.set(tc.etag, tc.data)
+		err := l.update()
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: expected an error at update", i)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: unexpected error at update : %s", i, err)
+		}
+		bs.Lock()
+		downloads := bs.downloads
+		bs.Unlock()
+		if downloads != tc.downloads {
+			t.Errorf("Test %d: expected %d downloads, got %d", i, tc.downloads, downloads)
+		}
+		for _, rule := range []policy.Rule{e, r} {
+			result, err := rule.Evaluate(input{"name": "example.org."})
+			if err != nil {
+				t.Errorf("Test %d: unexpected error at evaluate : %s", i, err)
+				continue
+			}
+			if result != tc.expected {
+				t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[tc.expected], policy.NameTypes[result])
+			}
+		}
+	}
+}
+
+func TestParseBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := writeTestFile(t, dir, "key", "secret")
+
+	tests := []struct {
+		config    string
+		shouldErr bool
+	}{
+		{"bundle http://127.0.0.1/b.tar.gz\nquery data.dns.action", true},
+		{"bundle http://127.0.0.1/b.tar.gz 30s\nbundle_key k1 " + keyFile + "\nquery data.dns.action", false},
+		{"bundle http://127.0.0.1/b.tar.gz", true},
+		{"bundle http://127.0.0.1/b.tar.gz 0s\nquery data.dns.action", true},
+		{"bundle http://127.0.0.1/b.tar.gz\nendpoint http://127.0.0.1\nquery data.dns.action", true},
+		{"bundle http://127.0.0.1/b.tar.gz\nbundle_key k1 " + keyFile + " XX256\nquery data.dns.action", true},
+		{"bundle http://127.0.0.1/b.tar.gz\nbundle_key k1 /nonexistent\nquery data.dns.action", true},
+		{"endpoint http://127.0.0.1\nbundle_key k1 " + keyFile, true},
+	}
+	for i, tc := range tests {
+		_, err := parse(caddy.NewTestController("dns", fmt.Sprintf("opa myengine {\n%s\n}", tc.config)))
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: expected an error for %q", i, tc.config)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: unexpected error for %q : %s", i, tc.config, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package opa
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// volatileFields are the fields of the structured input that change for each query, and would prevent any
+// cache hit: the ID of the header and the port of the client
+var volatileFields = [][2]string{{"header", "id"}, {"client", "port"}}
+
+// cacheKey return the key of the input in the cache for the path: the serialized input, without its
+// volatile fields
+func cacheKey(path string, in interface{}) (string, error) {
+	if m, ok := in.(input); ok {
+		in = withoutVolatileFields(m)
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	return path + " " + string(b), nil
+}
+
+// withoutVolatileFields return a copy of the input without the volatile fields, the input is unchanged
+func withoutVolatileFields(in input) input {
+	out := make(input, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	for _, f := range volatileFields {
+		obj, ok := in[f[0]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		c := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			if k != f[1] {
+				c[k] = v
+			}
+		}
+		out[f[0]] = c
+	}
+	return out
+}
+
+// cache stores the results of the policy for the serialized inputs, for a TTL and up to a number of entries,
+// the least recently used entries are removed first. Concurrent evaluations of the same input are coalesced:
+// the first one evaluates the policy, the others wait for its result.
+type cache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // entries, most recently used first
+	calls   map[string]*call
+}
+
+type cacheEntry struct {
+	key     string
+	result  interface{}
+	ok      bool
+	expires time.Time
+}
+
+// call is an evaluation in flight
+type call struct {
+	wg     sync.WaitGroup
+	result interface{}
+	ok     bool
+	err    error
+}
+
+// default TTL and maximum number of entries of the cache
+const (
+	defaultCacheTTL  = 10 * time.Second
+	defaultCacheSize = 10000
+)
+
+// cache lookup status
+const (
+	cacheMiss = iota
+	cacheHit
+	cacheCoalesced
+)
+
+func newCache(ttl time.Duration, size int) *cache {
+	return &cache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		calls:   make(map[string]*call),
+	}
+}
+
+// get return the result for the key from the cache, or evaluates it with eval and stores it in the cache.
+// Errors are not cached. status tells whether the result comes from the cache or from a concurrent evaluation
+func (c *cache) get(key string, eval func() (interface{}, bool, error)) (result interface{}, ok bool, status int, err error) {
+	c.mu.Lock()
+	if el, found := c.entries[key]; found {
+		ent := el.Value.(*cacheEntry)
+		if c.now().Before(ent.expires) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return ent.result, ent.ok, cacheHit, nil
+		}
+		c.remove(el)
+	}
+	if cl, found := c.calls[key]; found {
+		c.mu.Unlock()
+		cl.wg.Wait()
+		return cl.result, cl.ok, cacheCoalesced, cl.err
+	}
+	cl := new(call)
+	cl.wg.Add(1)
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	cl.result, cl.ok, cl.err = eval()
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if cl.err == nil {
+		c.add(&cacheEntry{key: key, result: cl.result, ok: cl.ok, expires: c.now().Add(c.ttl)})
+	}
+	c.mu.Unlock()
+	cl.wg.Done()
+	return cl.result, cl.ok, cacheMiss, cl.err
+}
+
+// len return the number of entries in the cache
+func (c *cache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// add adds the entry, and removes the least recently used entry if the cache is full. Must be called with the lock held
+func (c *cache) add(ent *cacheEntry) {
+	if el, found := c.entries[ent.key]; found {
+		c.remove(el)
+	}
+	if c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+	c.entries[ent.key] = c.lru.PushFront(ent)
+}
+
+// remove removes the entry. Must be called with the lock held
+func (c *cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,127 @@
+package opa
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := newCache(time.Minute, 2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	evals := 0
+	eval := func(result string) func() (interface{}, bool, error) {
+		return func() (interface{}, bool, error) {
+			evals++
+			return result, true, nil
+		}
+	}
+
+	for i, tc := range []struct {
+		key      string
+		expected string
+		status   int
+		evals    int
+		advance  time.Duration
+	}{
+		{"a", "a", cacheMiss, 1, 0},
+		{"a", "a", cacheHit, 1, 0},
+		{"b", "b", cacheMiss, 2, 0},
+		{"a", "a", cacheHit, 2, 0},
+		// c evicts b, the least recently used entry
+		{"c", "c", cacheMiss, 3, 0},
+		{"b", "b", cacheMiss, 4, 0},
+		{"b", "b", cacheHit, 4, 0},
+		// entries expire after the TTL
+		{"b", "b", cacheMiss, 5, time.Minute},
+	} {
+		now = now.Add(tc.advance)
+		r, ok, status, err := c.get(tc.key, eval(tc.key))
+		if err != nil || !ok {
+			t.Errorf("Test %d: unexpected result %v, %s", i, ok, err)
+		}
+		if r != tc.expected || status != tc.status || evals != tc.evals {
+			t.Errorf("Test %d: expected %s, status %d after %d evaluations, got %v, status %d after %d evaluations",
+				i, tc.expected, tc.status, tc.evals, r, status, evals)
+		}
+	}
+	if c.len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.len())
+	}
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	c := newCache(time.Minute, 10)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	evals := 0
+	eval := func() (interface{}, bool, error) {
+		evals++
+		close(started)
+		<-release
+		return "allow", true, nil
+	}
+
+	var wg sync.WaitGroup
+	statuses := make([]int, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _, statuses[0], _ = c.get("a", eval)
+	}()
+	<-started
+	for i := 1; i < len(statuses); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, statuses[i], _ = c.get("a", eval)
+		}(i)
+	}
+	// let the concurrent calls wait for the evaluation in flight
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if evals != 1 {
+		t.Errorf("expected 1 evaluation, got %d", evals)
+	}
+	if statuses[0] != cacheMiss {
+		t.Errorf("expected the first call to be a miss, got %d", statuses[0])
+	}
+	for i, s := range statuses[1:] {
+		if s == cacheMiss {
+			t.Errorf("call %d: expected a coalesced call or a hit, got a miss", i+1)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	structured := func(id, port int, name string) input {
+		return input{
+			"client":   map[string]interface{}{"ip": "192.0.2.1", "port": port},
+			"header":   map[string]interface{}{"id": id, "opcode": "QUERY"},
+			"question": map[string]interface{}{"name": name, "type": "A"},
+		}
+	}
+
+	in := structured(1, 53001, "example.com.")
+	key, err := cacheKey("dns/action", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := cacheKey("dns/action", structured(2, 53002, "example.com.")); other != key {
+		t.Errorf("expected the same key for queries with another id and port, got %s and %s", key, other)
+	}
+	if other, _ := cacheKey("dns/action", structured(1, 53001, "example.org.")); other == key {
+		t.Errorf("expected another key for another question, got %s", key)
+	}
+	if other, _ := cacheKey("dns/other", in); other == key {
+		t.Errorf("expected another key for another path, got %s", key)
+	}
+	if _, ok := in["header"].(map[string]interface{})["id"]; !ok {
+		t.Errorf("expected the input to be unchanged")
+	}
+}
@@ -0,0 +1,173 @@
+package opa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/policy/plugin/firewall/policy"
+
+	"github.com/miekg/dns"
+)
+
+// actions maps the actions returned by the policies to the actions of the firewall
+var actions = map[string]int{
+	"refuse": policy.TypeRefuse,
+	"allow":  policy.TypeAllow,
+	"block":  policy.TypeBlock,
+	"drop":   policy.TypeDrop,
+}
+
+// actionRedirect is the action of a decision replying with the answer records, a block with NOERROR by default
+const actionRedirect = "redirect"
+
+// defaultTTL is the TTL of the answer records synthesized from addresses and names
+const defaultTTL = 60
+
+// metadataPrefix is the prefix of the labels of the metadata returned in a decision
+const metadataPrefix = "opa/"
+
+// toDecision convert a decision object returned by the policy, e.g.
+// {"action": "redirect", "rcode": "NOERROR", "ede": 15, "ede_text": "malware", "ttl": 60, "answer": ["1.2.3.4"],
+// "metadata": {"category": "malware"}}
+// Only the action is required
+func toDecision(d *evalData, obj map[string]interface{}) (*policy.Decision, error) {
+	name, ok := obj["action"].(string)
+	if !ok {
+		return nil, fmt.Errorf("decision without action: %v", obj)
+	}
+	decision := &policy.Decision{}
+	if name == actionRedirect {
+		decision.Action = policy.TypeBlock
+		// the answer can be empty, e.g. an IPv4 address for a AAAA query: it is not a NXDOMAIN
+		noerror := dns.RcodeSuccess
+		decision.Rcode = &noerror
+	} else if decision.Action, ok = actions[name]; !ok {
+		return nil, fmt.Errorf("unknown action: '%s'", name)
+	}
+
+	if v, ok := obj["rcode"]; ok {
+		rcode, err := toRcode(v)
+		if err != nil {
+			return nil, err
+		}
+		decision.Rcode = &rcode
+	}
+	if v, ok := obj["ede"]; ok {
+		code, err := toInt(v)
+		if err != nil || code < 0 || code > 0xffff {
+			return nil, fmt.Errorf("invalid ede: %v", v)
+		}
+		text, _ := obj["ede_text"].(string)
+		decision.ExtendedError = &dns.EDNS0_EDE{InfoCode: uint16(code), ExtraText: text}
+	}
+	ttl := defaultTTL
+	if v, ok := obj["ttl"]; ok {
+		var err error
+		if ttl, err = toInt(v); err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid ttl: %v", v)
+		}
+	}
+	if v, ok := obj["answer"]; ok {
+		answers, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("answer is not a list: %v", v)
+		}
+		for _, a := range answers {
+			s, ok := a.(string)
+			if !ok {
+				return nil, fmt.Errorf("answer is not a string: %v", a)
+			}
+			rr, err := toRR(d, s, uint32(ttl))
+			if err != nil {
+				return nil, err
+			}
+			if rr != nil {
+				decision.Answer = append(decision.Answer, rr)
+			}
+		}
+	}
+	if v, ok := obj["metadata"]; ok {
+		md, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("metadata is not an object: %v", v)
+		}
+		for label, value := range md {
+			value := fmt.Sprint(value)
+			metadata.SetValueFunc(d.ctx, metadataPrefix+label, func() string { return value })
+		}
+	}
+	return decision, nil
+}
+
+// toRR return the answer record for a string of the answer of a decision:
+//   - an IP address is an A or AAAA record of the name of the question, if it is the type of the question
+//   - a record in presentation format, e.g. "example.org. 60 IN A 1.2.3.4", is used as is
+//   - a domain name is a CNAME record of the name of the question
+//
+// nil is returned if the address is not of the type of the question
+func toRR(d *evalData, s string, ttl uint32) (dns.RR, error) {
+	if strings.ContainsAny(s, " \t") {
+		rr, err := dns.NewRR(s)
+		if err != nil || rr == nil {
+			return nil, fmt.Errorf("invalid answer record '%s' : %v", s, err)
+		}
+		return rr, nil
+	}
+	if d.qname == "" {
+		return nil, fmt.Errorf("cannot build the answer '%s' without a question", s)
+	}
+	hdr := dns.RR_Header{Name: d.qname, Class: dns.ClassINET, Ttl: ttl}
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			if d.qtype != dns.TypeA && d.qtype != dns.TypeANY {
+				return nil, nil
+			}
+			hdr.Rrtype = dns.TypeA
+			return &dns.A{Hdr: hdr, A: ip4}, nil
+		}
+		if d.qtype != dns.TypeAAAA && d.qtype != dns.TypeANY {
+			return nil, nil
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	}
+	if _, ok := dns.IsDomainName(s); !ok {
+		return nil, fmt.Errorf("invalid answer '%s'", s)
+	}
+	hdr.Rrtype = dns.TypeCNAME
+	return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(s)}, nil
+}
+
+// toRcode return the rcode of a name, e.g. "NXDOMAIN", or of a number
+func toRcode(v interface{}) (int, error) {
+	if s, ok := v.(string); ok {
+		if rcode, ok := dns.StringToRcode[strings.ToUpper(s)]; ok {
+			return rcode, nil
+		}
+		return 0, fmt.Errorf("unknown rcode: '%s'", s)
+	}
+	rcode, err := toInt(v)
+	if err != nil || rcode < 0 || rcode > 0xfff {
+		return 0, fmt.Errorf("invalid rcode: %v", v)
+	}
+	return rcode, nil
+}
+
+// toInt return the integer value of a JSON number, decoded by the OPA client or by the local evaluation
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	case json.Number:
+		i, err := n.Int64()
+		if err == nil {
+			return int(i), nil
+		}
+	}
+	return 0, fmt.Errorf("not an integer: %v", v)
+}
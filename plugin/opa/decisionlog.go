@@ -0,0 +1,335 @@
+package opa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// default settings of the decision logs
+const (
+	defaultLogMaxSize  = 100 // megabytes
+	defaultLogBackups  = 3
+	defaultLogBatch    = 100
+	defaultLogInterval = 5 * time.Second
+	logQueueSize       = 10000
+)
+
+// decisionLog records the decisions of an engine with their input. The events are written in the background, to a
+// rotated file as JSON lines, or sent by batches to an HTTP endpoint in the format of the OPA decision logs.
+type decisionLog struct {
+	engine   string
+	sample   float64    // ratio of the decisions recorded
+	mask     [][]string // paths of the input fields removed from the events
+	sink     sink
+	batch    int           // number of events sent at once
+	interval time.Duration // maximum delay before an event is sent
+
+	events  chan []byte
+	dropped uint32
+	started bool // true once the sink is open and the background writer runs
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// decisionEvent is a decision, in the format of the OPA decision logs
+type decisionEvent struct {
+	DecisionID string            `json:"decision_id"`
+	Labels     map[string]string `json:"labels"`
+	Engine     string            `json:"engine"`
+	Path       string            `json:"path"`
+	Input      interface{}       `json:"input,omitempty"`
+	Result     interface{}       `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Erased     []string          `json:"erased,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Metrics    map[string]int64  `json:"metrics"`
+}
+
+// sink is the destination of the events. It is opened when the decision log starts
+type sink interface {
+	open() error
+	write(events [][]byte) error
+	close() error
+}
+
+func newDecisionLog(engine string, s sink, batch int, interval time.Duration) *decisionLog {
+	return &decisionLog{
+		engine:   engine,
+		sample:   1,
+		sink:     s,
+		batch:    batch,
+		interval: interval,
+		events:   make(chan []byte, logQueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// record queues the event of a decision. The input is serialized immediately, because it can be modified by the
+// evaluation of the response. The event is dropped if the queue is full
+func (l *decisionLog) record(path string, in interface{}, result interface{}, err error, start time.Time) {
+	if l.sample < 1 && mrand.Float64() >= l.sample {
+		return
+	}
+	ev := &decisionEvent{
+		DecisionID: decisionID(),
+		Labels:     map[string]string{"app": "coredns", "engine": l.engine},
+		Engine:     l.engine,
+		Path:       path,
+		Result:     result,
+		Timestamp:  start.UTC(),
+		Metrics:    map[string]int64{"timer_server_handler_ns": int64(time.Since(start))},
+	}
+	ev.Input, ev.Erased = l.maskInput(in)
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[ERROR] Cannot serialize OPA decision log event: %s", err)
+		return
+	}
+	select {
+	case l.events <- b:
+	default:
+		atomic.AddUint32(&l.dropped, 1)
+	}
+}
+
+// maskInput return the input without the masked fields, and the list of fields removed. The input is not modified
+func (l *decisionLog) maskInput(in interface{}) (interface{}, []string) {
+	var erased []string
+	for _, p := range l.mask {
+		var ok bool
+		if in, ok = remove(in, p); ok {
+			erased = append(erased, "/input/"+strings.Join(p, "/"))
+		}
+	}
+	return in, erased
+}
+
+// remove return a copy of v without the field at the path, and whether the field was found
+func remove(v interface{}, path []string) (interface{}, bool) {
+	var m map[string]interface{}
+	switch v := v.(type) {
+	case input:
+		m = v
+	case map[string]interface{}:
+		m = v
+	case map[string]string:
+		if len(path) != 1 {
+			return v, false
+		}
+		if _, ok := v[path[0]]; !ok {
+			return v, false
+		}
+		c := make(map[string]string, len(v))
+		for k, e := range v {
+			if k != path[0] {
+				c[k] = e
+			}
+		}
+		return c, true
+	default:
+		return v, false
+	}
+	f, ok := m[path[0]]
+	if !ok {
+		return v, false
+	}
+	c := make(map[string]interface{}, len(m))
+	for k, e := range m {
+		c[k] = e
+	}
+	if len(path) == 1 {
+		delete(c, path[0])
+		return c, true
+	}
+	if c[path[0]], ok = remove(f, path[1:]); !ok {
+		return v, false
+	}
+	return c, true
+}
+
+// decisionID return a random identifier of the decision
+func decisionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// start opens the sink and writes the events in the background, until stop is called
+func (l *decisionLog) start() error {
+	if err := l.sink.open(); err != nil {
+		return fmt.Errorf("cannot open decision log of engine %s : %s", l.engine, err)
+	}
+	l.started = true
+	go l.run()
+	return nil
+}
+
+// stop writes the pending events, and stops the background writer. Nothing is done if start did not succeed
+func (l *decisionLog) stop() {
+	if !l.started {
+		return
+	}
+	close(l.done)
+	<-l.stopped
+	l.started = false
+}
+
+func (l *decisionLog) run() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	var pending [][]byte
+	flush := func() {
+		if n := atomic.SwapUint32(&l.dropped, 0); n > 0 {
+			log.Printf("[WARN] OPA decision log of engine %s: %d events were dropped", l.engine, n)
+		}
+		if len(pending) == 0 {
+			return
+		}
+		if err := l.sink.write(pending); err != nil {
+			log.Printf("[ERROR] Cannot write OPA decision log of engine %s: %s", l.engine, err)
+		}
+		pending = nil
+	}
+	for {
+		select {
+		case b := <-l.events:
+			pending = append(pending, b)
+			if len(pending) >= l.batch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-l.done:
+			for len(l.events) > 0 {
+				pending = append(pending, <-l.events)
+			}
+			flush()
+			if err := l.sink.close(); err != nil {
+				log.Printf("[ERROR] Cannot close OPA decision log of engine %s: %s", l.engine, err)
+			}
+			return
+		}
+	}
+}
+
+// fileSink writes the events as JSON lines to a file. When the file exceeds its maximum size, it is renamed
+// with the suffix .1, the previous backups are shifted, and a new file is created
+type fileSink struct {
+	path    string
+	maxSize int64
+	backups int
+
+	f    *os.File
+	size int64
+}
+
+func newFileSink(path string, maxSize int64, backups int) *fileSink {
+	return &fileSink{path: path, maxSize: maxSize, backups: backups}
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = fi.Size()
+	return nil
+}
+
+func (s *fileSink) write(events [][]byte) error {
+	for _, b := range events {
+		if s.size > 0 && s.size+int64(len(b))+1 > s.maxSize {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := s.f.Write(append(b, '\n'))
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if s.backups == 0 {
+		os.Remove(s.path)
+	}
+	for i := s.backups; i > 0; i-- {
+		src := s.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", s.path, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", s.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.open()
+}
+
+func (s *fileSink) close() error { return s.f.Close() }
+
+// httpSink sends the events to an HTTP endpoint, as a gzip compressed JSON array like the OPA decision logs
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) write(events [][]byte) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte{'['})
+	zw.Write(bytes.Join(events, []byte{','}))
+	zw.Write([]byte{']'})
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%d events not accepted by %s : %s", len(events), s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) open() error { return nil }
+
+func (s *httpSink) close() error { return nil }
@@ -0,0 +1,226 @@
+package opa
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+func TestMaskInput(t *testing.T) {
+	in := input{
+		"client_ip": "10.0.0.1",
+		"name":      "example.org.",
+		"client":    map[string]interface{}{"ip": "10.0.0.1", "port": 53},
+		"metadata":  map[string]string{"user": "alice", "group": "admin"},
+	}
+	l := &decisionLog{mask: [][]string{{"client_ip"}, {"client", "ip"}, {"metadata", "user"}, {"unknown"}, {"name", "sub"}}}
+	masked, erased := l.maskInput(in)
+
+	expected := map[string]interface{}{
+		"name":     "example.org.",
+		"client":   map[string]interface{}{"port": 53},
+		"metadata": map[string]string{"group": "admin"},
+	}
+	if !reflect.DeepEqual(masked, expected) {
+		t.Errorf("expected masked input %v, got %v", expected, masked)
+	}
+	expectedErased := []string{"/input/client_ip", "/input/client/ip", "/input/metadata/user"}
+	if !reflect.DeepEqual(erased, expectedErased) {
+		t.Errorf("expected erased fields %v, got %v", expectedErased, erased)
+	}
+	// the input is not modified
+	if in["client_ip"] != "10.0.0.1" || len(in["client"].(map[string]interface{})) != 2 || len(in["metadata"].(map[string]string)) != 2 {
+		t.Errorf("the input was modified: %v", in)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "decisions.log")
+
+	s := newFileSink(path, 25, 2)
+	if err := s.open(); err != nil {
+		t.Fatal(err)
+	}
+	// each event fills a file
+	for _, ev := range []string{"event-1-01234567890", "event-2-01234567890", "event-3-01234567890", "event-4-01234567890"} {
+		if err := s.write([][]byte{[]byte(ev)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.close()
+
+	for f, expected := range map[string]string{
+		path:        "event-4-01234567890\n",
+		path + ".1": "event-3-01234567890\n",
+		path + ".2": "event-2-01234567890\n",
+	} {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("expected %s to contain %q, got %q", f, expected, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups")
+	}
+}
+
+func TestDecisionLogNotStarted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "decisions.log")
+
+	// the file is not opened while the Corefile is parsed
+	dl, err := parseDecisionLog(caddy.NewTestController("dns", ""), "fileengine", []string{"file", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the decision log file not to be created before startup")
+	}
+
+	// a shutdown after a failed startup returns
+	stopped := make(chan struct{})
+	go func() {
+		dl.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop of a decision log not started is blocked")
+	}
+
+	dl = newDecisionLog("fileengine", newFileSink(filepath.Join(dir, "missing", "decisions.log"), 1<<20, 0), 1, time.Second)
+	if err := dl.start(); err == nil {
+		t.Error("expected an error for a decision log file that cannot be created")
+	}
+	dl.stop()
+}
+
+func TestDecisionLog(t *testing.T) {
+	apiStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{\"result\":\"block\"}"))
+	}))
+	defer apiStub.Close()
+
+	events := make(chan []decisionEvent, 10)
+	logStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var evs []decisionEvent
+		if err := json.NewDecoder(zr).Decode(&evs); err != nil {
+			t.Error(err)
+		}
+		events <- evs
+	}))
+	defer logStub.Close()
+
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "decisions.log")
+
+	o, err := parse(caddy.NewTestController("dns", `opa httpengine {
+                 endpoint `+apiStub.URL+`
+                 decision_log http `+logStub.URL+` 2 1h
+                 decision_log_mask client_ip
+               }
+               opa fileengine {
+                 endpoint `+apiStub.URL+`
+                 decision_log file `+path+`
+               }`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range o.engines {
+		if err := e.decisionLog.start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// events are sent by batches of 2
+	e := o.engines["httpengine"]
+	e.Evaluate(input{"name": "example.org.", "client_ip": "10.0.0.1"})
+	r, _ := e.BuildRule([]string{"dns/action"})
+	r.Evaluate(input{"name": "example.com.", "client_ip": "10.0.0.1"})
+	var evs []decisionEvent
+	select {
+	case evs = <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no decision log received")
+	}
+	if len(evs) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evs))
+	}
+	for i, path := range []string{apiStub.URL, "dns/action"} {
+		ev := evs[i]
+		if ev.Path != path || ev.Engine != "httpengine" || ev.Result != "block" || ev.DecisionID == "" || ev.Timestamp.IsZero() {
+			t.Errorf("event %d: unexpected event %+v", i, ev)
+		}
+		if in, _ := ev.Input.(map[string]interface{}); in == nil || in["client_ip"] != nil || in["name"] == nil {
+			t.Errorf("event %d: expected the input without client_ip, got %v", i, ev.Input)
+		}
+		if !reflect.DeepEqual(ev.Erased, []string{"/input/client_ip"}) {
+			t.Errorf("event %d: expected client_ip to be erased, got %v", i, ev.Erased)
+		}
+	}
+
+	// events are written as JSON lines, and the pending events are written at stop
+	e = o.engines["fileengine"]
+	e.Evaluate(input{"name": "example.org."})
+	e.decisionLog.record("dns/action", input{"name": "example.net."}, nil, errors.New("unavailable"), time.Now())
+	for _, e := range o.engines {
+		e.decisionLog.stop()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []decisionEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev decisionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, ev)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events in the file, got %d", len(lines))
+	}
+	if lines[0].Result != "block" || lines[0].Error != "" {
+		t.Errorf("unexpected first event %+v", lines[0])
+	}
+	if lines[1].Result != nil || lines[1].Error != "unavailable" {
+		t.Errorf("unexpected second event %+v", lines[1])
+	}
+}
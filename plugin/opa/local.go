@@ -0,0 +1,195 @@
+package opa
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/policy/plugin/pkg/watch"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// ReloadInterval is the period used to check the policy and data files of local engines for changes
+var ReloadInterval = 5 * time.Second
+
+// local evaluates a Rego query in-process, against policies and data loaded from files, or from a bundle server.
+// The queries are prepared once at each load of the files, and the files are reloaded whenever they change on disk.
+type local struct {
+	query    string   // query to evaluate, e.g. data.dns.action
+	paths    []string // paths of the rules evaluated instead of the query, e.g. dns/query_action
+	policies []string // Rego policy files
+	data     []string // JSON or YAML data files
+	files    *watch.Watcher
+	bundle   *bundleSource // if set, the policies and data are downloaded from a bundle server instead of files
+	loading  sync.Mutex    // serializes the loads of the files
+	pending  bool          // true if paths were added since the last load
+
+	sync.RWMutex
+	prepared map[string]rego.PreparedEvalQuery // prepared queries by path, the query has an empty path
+}
+
+func newLocal(query string, policies, data []string) (*local, error) {
+	l := &local{query: query, policies: policies, data: data}
+	// the files are watched before they are loaded, so that a file rewritten during the load is reloaded
+	l.files = watch.New(ReloadInterval, append(append([]string{}, policies...), data...)...)
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// newBundleLocal return a local engine for the policies of a bundle server. No query is prepared until the first
+// bundle is activated
+func newBundleLocal(query string, s *bundleSource) *local {
+	return &local{query: query, bundle: s}
+}
+
+// addPath adds the query of the rule at the path, e.g. data.dns.query_action for dns/query_action. The queries of
+// all the rules are prepared together, at the start of the engine or at the first evaluation of one of them
+func (l *local) addPath(path string) {
+	l.loading.Lock()
+	defer l.loading.Unlock()
+	for _, p := range l.paths {
+		if p == path {
+			return
+		}
+	}
+	l.paths = append(l.paths, path)
+	l.pending = true
+}
+
+// prepare loads the policies again if paths were added since the last load, to prepare their queries
+func (l *local) prepare() error {
+	l.loading.Lock()
+	defer l.loading.Unlock()
+	if !l.pending {
+		return nil
+	}
+	return l.load()
+}
+
+// pathQuery return the query of the rule at the path
+func pathQuery(path string) string {
+	return "data." + strings.Replace(path, "/", ".", -1)
+}
+
+// load compiles the policies and prepares the queries, and replaces the previous queries only if it succeeds
+func (l *local) load() error {
+	var opts []func(*rego.Rego)
+	if l.bundle != nil {
+		if l.bundle.active == nil {
+			return nil
+		}
+		opts = append(opts, rego.ParsedBundle("bundle", l.bundle.active))
+	}
+	for _, f := range l.policies {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("cannot read policy %s : %s", f, err)
+		}
+		opts = append(opts, rego.Module(f, string(b)))
+	}
+	if len(l.data) > 0 {
+		res, err := loader.NewFileLoader().All(l.data)
+		if err != nil {
+			return fmt.Errorf("cannot load data : %s", err)
+		}
+		for f := range res.Modules {
+			return fmt.Errorf("cannot load data : %s is a policy", f)
+		}
+		store, err := res.Store()
+		if err != nil {
+			return fmt.Errorf("cannot load data : %s", err)
+		}
+		opts = append(opts, rego.Store(store))
+	}
+	queries := map[string]string{"": l.query}
+	for _, p := range l.paths {
+		queries[p] = pathQuery(p)
+	}
+	prepared := make(map[string]rego.PreparedEvalQuery)
+	for p, q := range queries {
+		pq, err := rego.New(append(opts, rego.Query(q))...).PrepareForEval(context.Background())
+		if err != nil {
+			return fmt.Errorf("cannot prepare query %s : %s", q, err)
+		}
+		prepared[p] = pq
+	}
+	l.Lock()
+	l.prepared = prepared
+	l.Unlock()
+	l.pending = false
+	return nil
+}
+
+func (l *local) reload() {
+	l.loading.Lock()
+	defer l.loading.Unlock()
+	if err := l.load(); err != nil {
+		log.Printf("[ERROR] Keeping previous OPA policy: %s", err)
+		return
+	}
+	log.Printf("[INFO] Reloaded OPA policy for query %s", l.query)
+}
+
+// start watches the policy and data files and reload them on change, or downloads the bundle periodically,
+// until stop is called
+func (l *local) start() {
+	if l.bundle != nil {
+		l.startBundle()
+		return
+	}
+	if err := l.prepare(); err != nil {
+		log.Printf("[ERROR] Cannot prepare the OPA rules: %s", err)
+	}
+	l.files.Start(l.reload)
+}
+
+func (l *local) stop() {
+	if l.bundle != nil {
+		l.stopBundle()
+		return
+	}
+	l.files.Stop()
+}
+
+// eval evaluates the query of the path with data as input, and return the value of the query, or false if it is
+// undefined. The query is evaluated if the path is empty
+func (l *local) eval(path string, data interface{}) (interface{}, bool, error) {
+	pq, ok, activated := l.preparedQuery(path)
+	if !ok && activated {
+		// the rules added after the start of the engine are prepared at their first evaluation
+		if err := l.prepare(); err != nil {
+			return nil, false, err
+		}
+		pq, ok, activated = l.preparedQuery(path)
+	}
+	if !ok {
+		if !activated && l.bundle != nil {
+			return nil, false, fmt.Errorf("no bundle activated from %s", l.bundle.url)
+		}
+		return nil, false, fmt.Errorf("no query prepared for path %s", path)
+	}
+	rs, err := pq.Eval(context.Background(), rego.EvalInput(data))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, false, nil
+	}
+	return rs[0].Expressions[0].Value, true, nil
+}
+
+// preparedQuery return the prepared query of the path, and false if there is none. The last value is false if no
+// query is prepared yet
+func (l *local) preparedQuery(path string) (rego.PreparedEvalQuery, bool, bool) {
+	l.RLock()
+	defer l.RUnlock()
+	pq, ok := l.prepared[path]
+	return pq, ok, l.prepared != nil
+}
@@ -0,0 +1,113 @@
+package opa
+
+import (
+	"sync"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "request_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time each evaluation of the policy took, including the requests to the OPA servers.",
+	}, []string{"engine"})
+	decisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "decisions_total",
+		Help:      "Counter of the results of the policy by action, or error if it could not be evaluated.",
+	}, []string{"engine", "action"})
+	responses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "responses_total",
+		Help:      "Counter of the responses of the OPA servers by HTTP status code.",
+	}, []string{"engine", "status"})
+	decodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "decode_errors_total",
+		Help:      "Counter of the responses of the OPA servers, and the results of the policy, that could not be decoded.",
+	}, []string{"engine"})
+	cacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "cache_hits_total",
+		Help:      "Counter of decisions returned from the decision cache.",
+	}, []string{"engine"})
+	cacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "cache_misses_total",
+		Help:      "Counter of decisions not found in the decision cache.",
+	}, []string{"engine"})
+	coalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "coalesced_total",
+		Help:      "Counter of decisions shared with a concurrent evaluation of the same input.",
+	}, []string{"engine"})
+	inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "in_flight_requests",
+		Help:      "Gauge of the evaluations of the policy in progress.",
+	}, []string{"engine"})
+	endpointRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "endpoint_requests_total",
+		Help:      "Counter of requests sent to each OPA server.",
+	}, []string{"engine", "endpoint"})
+	endpointFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "endpoint_failures_total",
+		Help:      "Counter of failed requests to each OPA server.",
+	}, []string{"engine", "endpoint"})
+	endpointEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "endpoint_ejections_total",
+		Help:      "Counter of ejections of each OPA server after consecutive failures.",
+	}, []string{"engine", "endpoint"})
+	endpointHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "opa",
+		Name:      "endpoint_healthy",
+		Help:      "Gauge of the health of each OPA server: 1 if it is available, 0 if it is ejected.",
+	}, []string{"engine", "endpoint"})
+)
+
+var metricsOnce sync.Once
+
+// setupMetrics registers the metrics of the plugin, if the prometheus plugin is enabled
+func setupMetrics(c *caddy.Controller) {
+	mh := dnsserver.GetConfig(c).Handler("prometheus")
+	if mh == nil {
+		return
+	}
+	if m, ok := mh.(*metrics.Metrics); ok {
+		metricsOnce.Do(func() {
+			m.MustRegister(requestDuration)
+			m.MustRegister(decisions)
+			m.MustRegister(responses)
+			m.MustRegister(decodeErrors)
+			m.MustRegister(cacheHits)
+			m.MustRegister(cacheMisses)
+			m.MustRegister(coalesced)
+			m.MustRegister(inFlight)
+			m.MustRegister(endpointRequests)
+			m.MustRegister(endpointFailures)
+			m.MustRegister(endpointEjections)
+			m.MustRegister(endpointHealthy)
+		})
+	}
+}
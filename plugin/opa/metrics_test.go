@@ -0,0 +1,60 @@
+package opa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	// the server returns the result named in the input
+	apiStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/allow":
+			w.Write([]byte("{\"result\":\"allow\"}"))
+		case "/unknown":
+			w.Write([]byte("{\"result\":\"unknown\"}"))
+		case "/invalid":
+			w.Write([]byte("{\"result\":"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer apiStub.Close()
+
+	o, err := parse(caddy.NewTestController("dns", "opa metricsengine {\nendpoint "+apiStub.URL+"\nmax_fails 0\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["metricsengine"]
+	for _, path := range []string{"allow", "allow", "unknown", "invalid", "missing"} {
+		r, err := e.BuildRule([]string{path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Evaluate(input{"name": "example.org."})
+	}
+
+	for _, tc := range []struct {
+		name     string
+		value    float64
+		expected float64
+	}{
+		{"allow decisions", testutil.ToFloat64(decisions.WithLabelValues("metricsengine", "allow")), 2},
+		{"error decisions", testutil.ToFloat64(decisions.WithLabelValues("metricsengine", "error")), 3},
+		{"responses 200", testutil.ToFloat64(responses.WithLabelValues("metricsengine", "200")), 4},
+		{"responses 404", testutil.ToFloat64(responses.WithLabelValues("metricsengine", "404")), 1},
+		{"decode errors", testutil.ToFloat64(decodeErrors.WithLabelValues("metricsengine")), 2},
+		{"in flight requests", testutil.ToFloat64(inFlight.WithLabelValues("metricsengine")), 0},
+	} {
+		if tc.value != tc.expected {
+			t.Errorf("expected %s to be %v, got %v", tc.name, tc.expected, tc.value)
+		}
+	}
+	if n := testutil.CollectAndCount(requestDuration); n == 0 {
+		t.Errorf("expected request durations to be collected")
+	}
+}
@@ -3,10 +3,16 @@ package opa
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metadata"
@@ -17,21 +23,73 @@ import (
 )
 
 // opa is a policy engine plugin for the firewall plugin that can validate DNS requests and
-// replies against OPA servers.
+// replies against OPA servers, or against Rego policies evaluated in-process.
 type opa struct {
 	engines map[string]*engine
 	next    plugin.Handler
 }
 
-// engine can validate DNS requests and replies against an OPA server.
+// engine can validate DNS requests and replies against an OPA server, or a local policy.
 type engine struct {
-	endpoint string // url to opa server api package e.g. http://example.com/v1/data/dns
-	client   *http.Client
-	fields   []string        // fields to send as input to opa
-	mapping  *rqdata.Mapping // store this so we dont have to rebuild it for every request
+	name      string
+	endpoints []*endpoint     // opa servers, with the url to the api package e.g. http://example.com/v1/data/dns
+	balance   string          // load balancing policy of the endpoints
+	next      uint32          // index of the endpoint of the next request in round robin
+	local     *local          // if set, the policy is evaluated in-process instead of by the OPA server
+	fields    []string        // fields to send as input to opa
+	mapping   *rqdata.Mapping // store this so we dont have to rebuild it for every request
+	typed     bool            // send the request data with their JSON type instead of strings
+	full      bool            // send a structured document of the whole DNS message instead of the fields
+
+	timeout   time.Duration // timeout of a request to the OPA server, including the read of the response
+	retries   int           // number of times a failed request to the OPA server is sent again
+	idleConns int           // maximum number of idle connections kept open to the OPA server
+	keepalive time.Duration // duration an idle connection is kept open, or 0 to disable keep-alive
+	onFailure int           // action returned when the policy cannot be evaluated, or failServfail
+
+	maxFails    int           // number of consecutive failures after which an endpoint is ejected, or 0 to never eject
+	failTimeout time.Duration // duration of the ejection of an endpoint
+
+	headers map[string]string // static headers of the requests to the OPA server
+	token   *tokenFile        // if set, the bearer token of the requests to the OPA server
+
+	cache       *cache       // if set, the results of the policy are cached
+	decisionLog *decisionLog // if set, the decisions are recorded
+}
+
+// failServfail is the onFailure mode where the error is returned, and the firewall replies SERVFAIL
+const failServfail = -1
+
+// failureModes maps the values of the on_failure option to the action returned on failure
+var failureModes = map[string]int{
+	"allow":    policy.TypeAllow,
+	"block":    policy.TypeBlock,
+	"refuse":   policy.TypeRefuse,
+	"servfail": failServfail,
+}
+
+type input map[string]interface{}
+
+// phases of the evaluation, given to the policy in the phase field of the input
+const (
+	phaseQuery    = "query"
+	phaseResponse = "response"
+)
+
+// evalData is the data of the policy for one DNS request or reply: the input of the policy, and the question
+// and context of the request, used to apply the decision
+type evalData struct {
+	ctx   context.Context
+	input input
+	qname string
+	qtype uint16
+
+	// decision is the last decision of the engine for this data, given to the policy of the response phase
+	decision map[string]interface{}
 }
 
-type input map[string]string
+// MarshalJSON implements the json.Marshaler interface, only the input is marshaled
+func (d *evalData) MarshalJSON() ([]byte, error) { return json.Marshal(d.input) }
 
 func newOpa() *opa {
 	return &opa{engines: make(map[string]*engine)}
@@ -39,9 +97,34 @@ func newOpa() *opa {
 
 func newEngine(m *rqdata.Mapping) *engine {
 	return &engine{
-		mapping: m,
-		fields:  []string{"client_ip", "name", "rcode", "response_ip"},
+		mapping:   m,
+		fields:    []string{"client_ip", "name", "rcode", "response_ip"},
+		timeout:   5 * time.Second,
+		idleConns: 32,
+		keepalive: 90 * time.Second,
+		onFailure: failServfail,
+
+		balance:     balancePriority,
+		maxFails:    3,
+		failTimeout: 10 * time.Second,
+	}
+}
+
+// newClient return the HTTP client of an OPA server, that keeps the connections open for reuse
+func (e *engine) newClient(socket string, tlsConfig *tls.Config) *http.Client {
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        e.idleConns,
+		MaxIdleConnsPerHost: e.idleConns,
+		IdleConnTimeout:     e.keepalive,
+		DisableKeepAlives:   e.keepalive == 0,
+	}
+	if socket != "" {
+		t.Proxy = nil
+		t.DialContext = dialUnix(socket)
 	}
+	return &http.Client{Timeout: e.timeout, Transport: t}
 }
 
 // Name implements the Handler interface
@@ -60,57 +143,269 @@ func (p *opa) Engine(name string) policy.Engine {
 
 // BuildQueryData implements the policy.Engine interface
 func (e *engine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
-	return e.buildData(ctx, state, make(input)), nil
+	in := e.buildData(ctx, state, make(input))
+	in["phase"] = phaseQuery
+	return &evalData{
+		ctx:   ctx,
+		input: in,
+		qname: state.Name(),
+		qtype: state.QType(),
+	}, nil
 }
 
-// BuildReplyData implements the policy.Engine interface
+// BuildReplyData implements the policy.Engine interface. The input of the response phase holds the values of the
+// query phase, and the decision of the engine in the query phase, if any
 func (e *engine) BuildReplyData(ctx context.Context, state request.Request, queryData interface{}) (interface{}, error) {
-	return e.buildData(ctx, state, queryData.(input)), nil
+	q, ok := queryData.(*evalData)
+	if !ok {
+		// the engine was not used in the query phase
+		qd, err := e.BuildQueryData(ctx, state)
+		if err != nil {
+			return nil, err
+		}
+		q = qd.(*evalData)
+	}
+	// copy the input of the query, it may still be evaluated by the rules of the query phase
+	in := make(input, len(q.input)+2)
+	for k, v := range q.input {
+		in[k] = v
+	}
+	if q.decision != nil {
+		in["query_decision"] = q.decision
+	}
+	in = e.buildData(ctx, state, in)
+	in["phase"] = phaseResponse
+	return &evalData{
+		ctx:   ctx,
+		input: in,
+		qname: q.qname,
+		qtype: q.qtype,
+	}, nil
+}
+
+// rule evaluates the policy at a path below the endpoint of the engine, or the corresponding rule of the local policy
+type rule struct {
+	e    *engine
+	path string
+}
+
+// BuildRule implements the policy.Engine interface. The optional argument is the path of the policy, relative to the
+// endpoint, e.g. dns/query_action. Without argument, the policy of the endpoint, or the query, is evaluated
+func (e *engine) BuildRule(args []string) (policy.Rule, error) {
+	if len(args) == 0 {
+		return e, nil
+	}
+	if len(args) > 1 {
+		return nil, fmt.Errorf("opa engine accepts at most one path, got %v", args)
+	}
+	path := strings.Trim(args[0], "/")
+	if path == "" {
+		return nil, fmt.Errorf("invalid opa path '%s'", args[0])
+	}
+	if e.local != nil {
+		e.local.addPath(path)
+	}
+	return &rule{e: e, path: path}, nil
+}
+
+// Evaluate implements the policy.Rule interface
+func (r *rule) Evaluate(data interface{}) (int, error) {
+	d, err := r.Decide(data)
+	if err != nil {
+		return 0, err
+	}
+	return d.Action, nil
 }
 
-// BuildRule implements the policy.Engine interface
-func (e *engine) BuildRule(args []string) (policy.Rule, error) { return e, nil }
+// Decide implements the policy.Decider interface
+func (r *rule) Decide(data interface{}) (*policy.Decision, error) { return r.e.decideAt(r.path, data) }
 
 // Evaluate implements the policy.Rule interface
 func (e *engine) Evaluate(data interface{}) (int, error) {
+	d, err := e.Decide(data)
+	if err != nil {
+		return 0, err
+	}
+	return d.Action, nil
+}
+
+// Decide implements the policy.Decider interface
+func (e *engine) Decide(data interface{}) (*policy.Decision, error) { return e.decideAt("", data) }
+
+// decideAt return the decision of the policy at the path, or of the default policy if the path is empty, and
+// applies the on_failure action on error
+func (e *engine) decideAt(path string, data interface{}) (*policy.Decision, error) {
+	d, err := e.decide(path, data)
+	if err != nil {
+		decisions.WithLabelValues(e.name, "error").Inc()
+	} else {
+		decisions.WithLabelValues(e.name, policy.NameTypes[d.Action]).Inc()
+	}
+	if err != nil && e.onFailure != failServfail {
+		log.Printf("[ERROR] OPA evaluation failed, applying action %s: %s", policy.NameTypes[e.onFailure], err)
+		d = &policy.Decision{Action: e.onFailure}
+		err = nil
+	}
+	if ed, ok := data.(*evalData); ok && ed.decision != nil && d != nil {
+		ed.decision["action"] = policy.NameTypes[d.Action]
+	}
+	return d, err
+}
+
+// decide return the decision of the policy. The result of the policy is either the name of an action,
+// or an object describing the decision
+func (e *engine) decide(path string, data interface{}) (*policy.Decision, error) {
+	var in interface{} = data
+	d, ok := data.(*evalData)
+	if ok {
+		in = d.input
+	} else {
+		// data built outside of the engine, there is no request to apply the decision to
+		d = &evalData{ctx: context.Background()}
+	}
+	start := time.Now()
+	result, ok, err := e.evaluate(path, in)
+	if e.decisionLog != nil {
+		e.decisionLog.record(e.logPath(path), in, result, err, start)
+	}
+	d.decision = map[string]interface{}{"path": e.logPath(path)}
+	if err != nil {
+		d.decision["error"] = err.Error()
+		return nil, err
+	}
+	if ok {
+		d.decision["result"] = result
+	}
+	if !ok {
+		return &policy.Decision{Action: policy.TypeNone}, nil
+	}
+	if obj, ok := result.(map[string]interface{}); ok {
+		dec, err := toDecision(d, obj)
+		if err != nil {
+			decodeErrors.WithLabelValues(e.name).Inc()
+		}
+		return dec, err
+	}
+	name, _ := result.(string)
+	action, ok := actions[name]
+	if !ok {
+		decodeErrors.WithLabelValues(e.name).Inc()
+		return nil, fmt.Errorf("unknown action: '%v'", result)
+	}
+	return &policy.Decision{Action: action}, nil
+}
+
+// evaluate return the result of the policy at the path for the input, or false if it is undefined. With a cache,
+// the result is looked up in the cache first, keyed on the path and the serialized input without its volatile
+// fields
+func (e *engine) evaluate(path string, in interface{}) (interface{}, bool, error) {
+	if e.cache == nil {
+		return e.eval(path, in)
+	}
+	key, err := cacheKey(path, in)
+	if err != nil {
+		return nil, false, err
+	}
+	result, ok, status, err := e.cache.get(key, func() (interface{}, bool, error) { return e.eval(path, in) })
+	switch status {
+	case cacheHit:
+		cacheHits.WithLabelValues(e.name).Inc()
+	case cacheCoalesced:
+		coalesced.WithLabelValues(e.name).Inc()
+	default:
+		cacheMisses.WithLabelValues(e.name).Inc()
+	}
+	return result, ok, err
+}
+
+// eval evaluates the policy, in-process or by the OPA server
+func (e *engine) eval(path string, in interface{}) (interface{}, bool, error) {
+	inFlight.WithLabelValues(e.name).Inc()
+	defer inFlight.WithLabelValues(e.name).Dec()
+	defer func(start time.Time) {
+		requestDuration.WithLabelValues(e.name).Observe(time.Since(start).Seconds())
+	}(time.Now())
+	if e.local != nil {
+		return e.local.eval(path, in)
+	}
+	return e.query(path, in)
+}
+
+// logPath return the path of the policy recorded in the decision logs
+func (e *engine) logPath(path string) string {
+	switch {
+	case path != "":
+		return path
+	case e.local != nil:
+		return e.local.query
+	default:
+		return e.endpoints[0].name()
+	}
+}
+
+// query sends the data to the policy at the path of the OPA servers, and return the result of the policy, or false
+// if it is undefined. A request that fails is sent to the next endpoint, each endpoint available is tried once, then
+// the request is sent again up to the number of retries
+func (e *engine) query(path string, data interface{}) (interface{}, bool, error) {
 	// put all query/response data in "input" field, and marshal to json
 	bdata, err := json.Marshal(map[string]interface{}{"input": data})
 	if err != nil {
-		return 0, err
+		return nil, false, err
+	}
+
+	endpoints, healthy := e.candidates()
+	if healthy == 0 {
+		healthy = 1
+	}
+	for i := 0; ; i++ {
+		u := endpoints[i%len(endpoints)]
+		action, ok, retry, err := e.post(u, path, bdata)
+		e.report(u, err != nil && retry)
+		if err == nil || !retry || i >= e.retries+healthy-1 {
+			return action, ok, err
+		}
 	}
+}
 
+// post sends one request to an OPA server. If it fails, retry tells whether the request can be sent again
+func (e *engine) post(u *endpoint, path string, bdata []byte) (action interface{}, ok bool, retry bool, err error) {
 	// send to opa api
-	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewBuffer(bdata))
+	req, err := http.NewRequest(http.MethodPost, u.policyURL(path), bytes.NewReader(bdata))
 	if err != nil {
-		return 0, err
+		return nil, false, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	e.setHeaders(req)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, false, true, err
+	}
+	// the body must be read to the end and closed, for the connection to be reused
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	responses.WithLabelValues(e.name, strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, resp.StatusCode >= http.StatusInternalServerError, fmt.Errorf("OPA server returned status %s", resp.Status)
 	}
 
 	// decode response
 	var result map[string]interface{}
 	err = json.NewDecoder(resp.Body).Decode(&result)
 	if err != nil {
-		return 0, err
-	}
-	action, ok := result["result"]
-	if !ok {
-		return policy.TypeNone, nil
-	}
-	switch action {
-	case "refuse":
-		return policy.TypeRefuse, nil
-	case "allow":
-		return policy.TypeAllow, nil
-	case "block":
-		return policy.TypeBlock, nil
-	case "drop":
-		return policy.TypeDrop, nil
-	default:
-		return 0, fmt.Errorf("unknown action: '%s'", action)
+		decodeErrors.WithLabelValues(e.name).Inc()
+		return nil, false, true, err
 	}
+	action, ok = result["result"]
+	return action, ok, false, nil
 }
 
 // buildData fills the map of values for policy input
 func (e *engine) buildData(ctx context.Context, state request.Request, data input) input {
+	if e.full {
+		return e.buildStructured(ctx, state, data)
+	}
 	extractor := rqdata.NewExtractor(state, e.mapping)
 	for _, f := range e.fields {
 		if _, ok := data[f]; ok {
@@ -120,6 +415,17 @@ func (e *engine) buildData(ctx context.Context, state request.Request, data inpu
 		var v string
 		var ok bool
 		if e.mapping.ValidField(f) {
+			if e.typed {
+				// numbers, booleans, lists and IP addresses are marshaled with their JSON type
+				if tv, _ := extractor.TypedValue(f); tv != nil {
+					data[f] = tv
+				}
+				continue
+			}
+			if tv, _ := extractor.TypedValue(f); tv == nil {
+				// not available yet, e.g. the response data of a query: left to the response phase
+				continue
+			}
 			v, ok = extractor.Value(f)
 			if !ok {
 				continue
@@ -3,11 +3,18 @@ package opa
 import (
 	"context"
 	"encoding/json"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
 	"github.com/coredns/policy/plugin/firewall/policy"
@@ -62,6 +69,138 @@ func TestEvaluate(t *testing.T) {
 	}
 }
 
+func TestEvaluateFailure(t *testing.T) {
+	var requests int32
+	var conns int32
+	// the server fails the first request of each pair with an error 503, and is slow for the name "slow."
+	apiStub := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result map[string]map[string]string
+		json.NewDecoder(r.Body).Decode(&result)
+		if result["input"]["name"] == "slow." {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("{\"error\":\"unavailable\"}"))
+			return
+		}
+		w.Write([]byte("{\"result\":\"allow\"}"))
+	}))
+	apiStub.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	apiStub.Start()
+	defer apiStub.Close()
+
+	tests := []struct {
+		config    string
+		name      string
+		expected  int
+		shouldErr bool
+	}{
+		{"", "example.org.", 0, true},
+		{"retries 1", "example.org.", policy.TypeAllow, false},
+		{"on_failure block", "example.org.", policy.TypeBlock, false},
+		{"on_failure servfail", "example.org.", 0, true},
+		{"timeout 50ms\nretries 1", "slow.", 0, true},
+		{"timeout 50ms\non_failure refuse", "slow.", policy.TypeRefuse, false},
+	}
+	for i, tc := range tests {
+		o, err := parse(caddy.NewTestController("dns", "opa myengine {\nendpoint "+apiStub.URL+"\n"+tc.config+"\n}"))
+		if err != nil {
+			t.Fatalf("Test %d: unexpected error at parse : %s", i, err)
+		}
+		// each test starts with a failed request
+		atomic.StoreInt32(&requests, 0)
+		result, err := o.engines["myengine"].Evaluate(input{"name": tc.name})
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error at evaluate", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error at evaluate : %s", i, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[tc.expected], policy.NameTypes[result])
+		}
+	}
+
+	// the connection is reused after the failures
+	o, err := parse(caddy.NewTestController("dns", "opa myengine {\nendpoint "+apiStub.URL+"\nretries 1\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&conns, 0)
+	for i := 0; i < 10; i++ {
+		atomic.StoreInt32(&requests, 0)
+		if _, err := o.engines["myengine"].Evaluate(input{"name": "example.org."}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("expected 1 connection to the OPA server, got %d", n)
+	}
+}
+
+func TestEvaluateCache(t *testing.T) {
+	var requests int32
+	apiStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result map[string]map[string]string
+		json.NewDecoder(r.Body).Decode(&result)
+		atomic.AddInt32(&requests, 1)
+		if result["input"]["name"] == "error." {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("{\"result\":\"block\"}"))
+	}))
+	defer apiStub.Close()
+
+	o, err := parse(caddy.NewTestController("dns", "opa myengine {\nendpoint "+apiStub.URL+"\ncache 1m 100\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["myengine"]
+
+	tests := []struct {
+		name      string
+		requests  int32
+		shouldErr bool
+	}{
+		{"example.org.", 1, false},
+		{"example.org.", 1, false},
+		{"example.com.", 2, false},
+		{"example.org.", 2, false},
+		// errors are not cached
+		{"error.", 3, true},
+		{"error.", 4, true},
+	}
+	for i, tc := range tests {
+		result, err := e.Evaluate(input{"name": tc.name})
+		if n := atomic.LoadInt32(&requests); n != tc.requests {
+			t.Errorf("Test %d: expected %d requests to the OPA server, got %d", i, tc.requests, n)
+		}
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error at evaluate", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error at evaluate : %s", i, err)
+			continue
+		}
+		if result != policy.TypeBlock {
+			t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[policy.TypeBlock], policy.NameTypes[result])
+		}
+	}
+}
+
 func TestBuildQueryData(t *testing.T) {
 	w := response.NewReader(&test.ResponseWriter{})
 	r := new(dns.Msg)
@@ -75,7 +214,7 @@ func TestBuildQueryData(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	data := d.(input)
+	data := d.(*evalData).input
 
 	if data["client_ip"] != "10.240.0.1" {
 		t.Errorf("expected client_ip == '10.240.0.1'. Got '%v'", data["client_ip"])
@@ -99,12 +238,12 @@ func TestBuildReplyData(t *testing.T) {
 	e := newEngine(rqdata.NewMapping(""))
 	ctx := context.TODO()
 
-	indata := input{"client_ip": "10.240.0.1", "name": "test.data.exists."}
+	indata := &evalData{input: input{"client_ip": "10.240.0.1", "name": "test.data.exists."}}
 	d, err := e.BuildReplyData(ctx, state, indata)
 	if err != nil {
 		t.Error(err)
 	}
-	data := d.(input)
+	data := d.(*evalData).input
 
 	if data["name"] != "test.data.exists." {
 		t.Errorf("expected name == 'test.data.exists.'. Got '%v'", data["name"])
@@ -118,3 +257,561 @@ func TestBuildReplyData(t *testing.T) {
 		t.Errorf("expected response_ip == '1.2.3.4'. Got '%v'", data["response_ip"])
 	}
 }
+
+func TestBuildDataPhase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the query phase flags the names of a suspicious domain, the response phase blocks them only if they
+	// resolve to a private address
+	policyFile := writeTestFile(t, dir, "dns.rego", `package dns
+
+query = "allow" { input.phase == "query" }
+
+suspicious { endswith(input.name, ".example.net.") }
+
+response = "block" {
+  input.phase == "response"
+  input.query_decision.result == "allow"
+  input.query_decision.path == "dns/query"
+  suspicious
+  net.cidr_contains("10.0.0.0/8", input.response_ip)
+}
+`)
+	l, err := newLocal("data.dns.query", []string{policyFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newEngine(rqdata.NewMapping(""))
+	e.local = l
+	queryRule, err := e.BuildRule([]string{"dns/query"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseRule, err := e.BuildRule([]string{"dns/response"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		query    bool // the query phase evaluated the engine
+		expected int
+	}{
+		{"www.example.net.", "10.0.0.1", true, policy.TypeBlock},
+		{"www.example.net.", "192.0.2.1", true, policy.TypeNone},
+		{"www.example.org.", "10.0.0.1", true, policy.TypeNone},
+		{"www.example.net.", "10.0.0.1", false, policy.TypeNone},
+	}
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tc.name, dns.TypeA)
+		state := request.Request{W: response.NewReader(&test.ResponseWriter{}), Req: r}
+		var qd interface{}
+		if tc.query {
+			qd, err = e.BuildQueryData(context.TODO(), state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if phase := qd.(*evalData).input["phase"]; phase != "query" {
+				t.Errorf("Test %d: expected phase query, got %v", i, phase)
+			}
+			if action, err := queryRule.Evaluate(qd); err != nil || action != policy.TypeAllow {
+				t.Errorf("Test %d: expected allow in the query phase, got %d, error : %v", i, action, err)
+			}
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{test.A(tc.name + " 60 IN A " + tc.ip)}
+		state = request.Request{W: &response.Reader{ResponseWriter: &test.ResponseWriter{}, Msg: m}, Req: r}
+		rd, err := e.BuildReplyData(context.TODO(), state, qd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if phase := rd.(*evalData).input["phase"]; phase != "response" {
+			t.Errorf("Test %d: expected phase response, got %v", i, phase)
+		}
+		if qd != nil {
+			if phase := qd.(*evalData).input["phase"]; phase != "query" {
+				t.Errorf("Test %d: expected the query input to be unchanged, got phase %v", i, phase)
+			}
+		}
+		action, err := responseRule.Evaluate(rd)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error : %s", i, err)
+			continue
+		}
+		if action != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[tc.expected], policy.NameTypes[action])
+		}
+	}
+}
+
+func TestBuildQueryDataTyped(t *testing.T) {
+	w := response.NewReader(&test.ResponseWriter{})
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	state := request.Request{W: w, Req: r}
+
+	e := newEngine(rqdata.NewMapping(""))
+	e.typed = true
+	e.fields = []string{"client_ip", "name", "size", ">do", "rcode"}
+
+	d, err := e.BuildQueryData(context.TODO(), state)
+	if err != nil {
+		t.Error(err)
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"\u003edo":false,"client_ip":"10.240.0.1","name":"example.org.","phase":"query","size":29}`
+	if string(b) != expected {
+		t.Errorf("expected input %s. Got %s", expected, string(b))
+	}
+}
+
+const testPolicy = `package dns
+
+default action = "allow"
+
+action = "block" { input.name == data.blocked[_] }
+
+action = "refuse" { input.client_ip == "10.0.0.1" }
+`
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEvaluateLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := writeTestFile(t, dir, "dns.rego", testPolicy)
+	dataFile := writeTestFile(t, dir, "data.json", `{"blocked": ["example.org."]}`)
+
+	o, err := parse(caddy.NewTestController("dns",
+		`opa myengine {
+                 policy `+policyFile+`
+                 data `+dataFile+`
+                 query data.dns.action
+               }`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["myengine"]
+
+	tests := []struct {
+		data     input
+		expected int
+	}{
+		{input{"name": "example.org.", "client_ip": "10.240.0.1"}, policy.TypeBlock},
+		{input{"name": "example.com.", "client_ip": "10.0.0.1"}, policy.TypeRefuse},
+		{input{"name": "example.com.", "client_ip": "10.240.0.1"}, policy.TypeAllow},
+	}
+	for i, test := range tests {
+		result, err := e.Evaluate(test.data)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error at evaluate : %s", i, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, test.expected, result)
+		}
+	}
+
+	// undefined query has no action
+	l, err := newLocal("data.dns.unknown", []string{policyFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := (&engine{local: l}).Evaluate(input{"name": "example.org."})
+	if err != nil || result != policy.TypeNone {
+		t.Errorf("expected no action and no error for an undefined query, got %d, error : %v", result, err)
+	}
+}
+
+func TestBuildRule(t *testing.T) {
+	// the server returns the path of the request as action
+	apiStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/data/dns/query_action":
+			w.Write([]byte("{\"result\":\"refuse\"}"))
+		case "/v1/data/dns/response_action":
+			w.Write([]byte("{\"result\":\"drop\"}"))
+		case "/v1/data/":
+			w.Write([]byte("{\"result\":\"allow\"}"))
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer apiStub.Close()
+
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := writeTestFile(t, dir, "dns.rego", `package dns
+
+action = "allow"
+
+query_action = "refuse"
+
+response_action = "drop"
+`)
+
+	for _, config := range []string{
+		"endpoint " + apiStub.URL + "/v1/data/",
+		"policy " + policyFile + "\nquery data.dns.action",
+	} {
+		o, err := parse(caddy.NewTestController("dns", "opa myengine {\n"+config+"\n}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := o.engines["myengine"]
+
+		tests := []struct {
+			args      []string
+			expected  int
+			shouldErr bool
+		}{
+			{nil, policy.TypeAllow, false},
+			{[]string{"dns/query_action"}, policy.TypeRefuse, false},
+			{[]string{"/dns/response_action"}, policy.TypeDrop, false},
+			{[]string{"dns/unknown"}, policy.TypeNone, false},
+			{[]string{"/"}, 0, true},
+			{[]string{"dns/query_action", "dns/response_action"}, 0, true},
+		}
+		for i, tc := range tests {
+			r, err := e.BuildRule(tc.args)
+			if tc.shouldErr {
+				if err == nil {
+					t.Errorf("Test %d (%s): expected an error at build rule", i, config)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Test %d (%s): unexpected error at build rule : %s", i, config, err)
+				continue
+			}
+			result, err := r.Evaluate(input{"name": "example.org."})
+			if err != nil {
+				t.Errorf("Test %d (%s): unexpected error at evaluate : %s", i, config, err)
+				continue
+			}
+			if result != tc.expected {
+				t.Errorf("Test %d (%s): expected %s, got %s", i, config, policy.NameTypes[tc.expected], policy.NameTypes[result])
+			}
+		}
+	}
+}
+
+func TestPreparePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := writeTestFile(t, dir, "dns.rego", `package dns
+
+action = "allow"
+
+query_action = "refuse"
+`)
+
+	l, err := newLocal("data.dns.action", []string{policyFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the queries of the rules are not prepared when they are added
+	for _, p := range []string{"dns/query_action", "dns/response_action", "dns/query_action"} {
+		l.addPath(p)
+	}
+	if len(l.prepared) != 1 || !l.pending {
+		t.Fatalf("expected only the query to be prepared, got %d queries", len(l.prepared))
+	}
+
+	// they are all prepared at once when the engine starts
+	l.start()
+	defer l.stop()
+	if len(l.prepared) != 3 || l.pending {
+		t.Fatalf("expected 3 prepared queries, got %d", len(l.prepared))
+	}
+
+	// a rule added later is prepared at its first evaluation
+	l.addPath("dns/action")
+	result, ok, err := l.eval("dns/query_action", nil)
+	if err != nil || !ok || result != "refuse" {
+		t.Errorf("expected refuse, got %v, %v, %v", result, ok, err)
+	}
+	result, ok, err = l.eval("dns/action", nil)
+	if err != nil || !ok || result != "allow" {
+		t.Errorf("expected allow, got %v, %v, %v", result, ok, err)
+	}
+}
+
+func TestReloadLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := writeTestFile(t, dir, "dns.rego", testPolicy)
+	dataFile := writeTestFile(t, dir, "data.json", `{"blocked": ["example.org."]}`)
+
+	l, err := newLocal("data.dns.action", []string{policyFile}, []string{dataFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &engine{local: l}
+	data := input{"name": "example.com."}
+	if result, err := e.Evaluate(data); err != nil || result != policy.TypeAllow {
+		t.Fatalf("expected allow before reload, got %d, error : %v", result, err)
+	}
+
+	writeTestFile(t, dir, "data.json", `{"blocked": ["example.com."]}`)
+	if err := l.load(); err != nil {
+		t.Fatalf("unexpected error at reload : %s", err)
+	}
+	if result, err := e.Evaluate(data); err != nil || result != policy.TypeBlock {
+		t.Errorf("expected block after reload, got %d, error : %v", result, err)
+	}
+
+	// a broken policy keeps the previous one active
+	writeTestFile(t, dir, "dns.rego", "package dns\naction = ")
+	if err := l.load(); err == nil {
+		t.Errorf("expected an error at reload of an invalid policy")
+	}
+	if result, err := e.Evaluate(data); err != nil || result != policy.TypeBlock {
+		t.Errorf("expected block to be kept after failed reload, got %d, error : %v", result, err)
+	}
+}
+
+const testDecisionPolicy = `package dns
+
+decision = {"action": "redirect", "answer": ["192.0.2.1", "2001:db8::1"], "ttl": 30, "ede": 15, "ede_text": "malware", "metadata": {"category": "malware"}} { input.name == "malware.example." }
+
+decision = {"action": "block", "rcode": "SERVFAIL"} { input.name == "servfail.example." }
+
+decision = {"action": "redirect", "answer": ["walled.example.org"]} { input.name == "cname.example." }
+
+decision = {"action": "refuse", "answer": ["refused.example. 10 IN TXT \"refused\""]} { input.name == "refused.example." }
+
+decision = "allow" { input.name == "allow.example." }
+
+decision = {"action": "unknown"} { input.name == "unknown.example." }
+
+decision = {"action": "block", "rcode": 4096} { input.name == "rcode.example." }
+
+decision = {"answer": ["192.0.2.1"]} { input.name == "noaction.example." }
+`
+
+func TestDecide(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := writeTestFile(t, dir, "dns.rego", testDecisionPolicy)
+
+	o, err := parse(caddy.NewTestController("dns",
+		`opa myengine {
+                 policy `+policyFile+`
+                 query data.dns.decision
+               }`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := o.engines["myengine"]
+
+	tests := []struct {
+		name      string
+		qtype     uint16
+		expected  int
+		rcode     int
+		answer    []string
+		ede       bool
+		shouldErr bool
+	}{
+		{"malware.example.", dns.TypeA, policy.TypeBlock, dns.RcodeSuccess, []string{"malware.example.\t30\tIN\tA\t192.0.2.1"}, true, false},
+		{"malware.example.", dns.TypeAAAA, policy.TypeBlock, dns.RcodeSuccess, []string{"malware.example.\t30\tIN\tAAAA\t2001:db8::1"}, true, false},
+		{"malware.example.", dns.TypeMX, policy.TypeBlock, dns.RcodeSuccess, nil, true, false},
+		{"servfail.example.", dns.TypeA, policy.TypeBlock, dns.RcodeServerFailure, nil, false, false},
+		{"cname.example.", dns.TypeA, policy.TypeBlock, dns.RcodeSuccess, []string{"cname.example.\t60\tIN\tCNAME\twalled.example.org."}, false, false},
+		{"refused.example.", dns.TypeTXT, policy.TypeRefuse, -1, []string{"refused.example.\t10\tIN\tTXT\t\"refused\""}, false, false},
+		{"allow.example.", dns.TypeA, policy.TypeAllow, -1, nil, false, false},
+		{"other.example.", dns.TypeA, policy.TypeNone, -1, nil, false, false},
+		{"unknown.example.", dns.TypeA, 0, 0, nil, false, true},
+		{"rcode.example.", dns.TypeA, 0, 0, nil, false, true},
+		{"noaction.example.", dns.TypeA, 0, 0, nil, false, true},
+	}
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tc.name, tc.qtype)
+		ctx := metadata.ContextWithMetadata(context.TODO())
+		data, err := e.BuildQueryData(ctx, request.Request{W: &test.ResponseWriter{}, Req: r})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := e.Decide(data)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error at decide", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error at decide : %s", i, err)
+			continue
+		}
+		if d.Action != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[tc.expected], policy.NameTypes[d.Action])
+		}
+		if (d.Rcode == nil && tc.rcode >= 0) || (d.Rcode != nil && *d.Rcode != tc.rcode) {
+			t.Errorf("Test %d: expected rcode %d, got %v", i, tc.rcode, d.Rcode)
+		}
+		if len(d.Answer) != len(tc.answer) {
+			t.Errorf("Test %d: expected answer %v, got %v", i, tc.answer, d.Answer)
+		} else {
+			for j, rr := range d.Answer {
+				if rr.String() != tc.answer[j] {
+					t.Errorf("Test %d: expected answer %s, got %s", i, tc.answer[j], rr.String())
+				}
+			}
+		}
+		if (d.ExtendedError != nil) != tc.ede {
+			t.Errorf("Test %d: expected extended error : %v, got %v", i, tc.ede, d.ExtendedError)
+		}
+		if tc.ede {
+			if d.ExtendedError.InfoCode != 15 || d.ExtendedError.ExtraText != "malware" {
+				t.Errorf("Test %d: unexpected extended error %v", i, d.ExtendedError)
+			}
+			if f := metadata.ValueFunc(ctx, "opa/category"); f == nil || f() != "malware" {
+				t.Errorf("Test %d: expected metadata opa/category to be set", i)
+			}
+		}
+	}
+
+	// decisions returned by an OPA server
+	apiStub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"action":"block","rcode":5,"ede":17}}`))
+	}))
+	defer apiStub.Close()
+	o, err = parse(caddy.NewTestController("dns", "opa myengine {\nendpoint "+apiStub.URL+"\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := o.engines["myengine"].Decide(input{"name": "example.org."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Action != policy.TypeBlock || d.Rcode == nil || *d.Rcode != dns.RcodeRefused || d.ExtendedError == nil || d.ExtendedError.InfoCode != 17 {
+		t.Errorf("unexpected decision from the OPA server %v", d)
+	}
+}
+
+func TestBuildStructuredData(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	r.Id = 1234
+	r.SetEdns0(4096, true)
+	opt := r.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+	)
+
+	e := newEngine(rqdata.NewMapping(""))
+	e.full = true
+	ctx := metadata.ContextWithMetadata(context.TODO())
+	metadata.SetValueFunc(ctx, "test/label", func() string { return "value" })
+
+	state := request.Request{W: response.NewReader(&test.ResponseWriter{}), Req: r}
+	d, err := e.BuildQueryData(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"client":{"ip":"10.240.0.1","port":40212,"protocol":"udp","server_ip":"127.0.0.1","server_port":53},` +
+		`"edns":{"cookie":{"client":"0102030405060708","server":""},"do":true,` +
+		`"options":[{"code":8,"data":"192.0.2.0/24/0"},{"code":10,"data":"0102030405060708"}],` +
+		`"subnet":{"address":"192.0.2.0","family":1,"scope_prefix":0,"source_prefix":24},"udp_size":4096,"version":0},` +
+		`"header":{"flags":["rd"],"id":1234,"opcode":"QUERY"},"metadata":{"test/label":"value"},"phase":"query",` +
+		`"question":{"class":"IN","name":"www.example.org.","type":"A"}}`
+	if string(b) != expected {
+		t.Errorf("expected input %s.\nGot %s", expected, string(b))
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = []dns.RR{
+		test.CNAME("www.example.org. 300 IN CNAME cdn.example.net."),
+		test.A("cdn.example.net. 60 IN A 192.0.2.1"),
+		test.A("cdn.example.net. 60 IN A 192.0.2.2"),
+	}
+	m.Ns = []dns.RR{test.NS("example.net. 3600 IN NS ns.example.net.")}
+	state = request.Request{W: &response.Reader{Msg: m}, Req: r}
+	d, err = e.BuildReplyData(ctx, state, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = json.Marshal(d.(*evalData).input["response"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"additional":[],"answer":[` +
+		`{"class":"IN","name":"www.example.org.","rdata":"cdn.example.net.","ttl":300,"type":"CNAME"},` +
+		`{"class":"IN","name":"cdn.example.net.","rdata":"192.0.2.1","ttl":60,"type":"A"},` +
+		`{"class":"IN","name":"cdn.example.net.","rdata":"192.0.2.2","ttl":60,"type":"A"}],` +
+		`"authority":[{"class":"IN","name":"example.net.","rdata":"ns.example.net.","ttl":3600,"type":"NS"}],` +
+		`"flags":["qr","rd"],"id":1234,"opcode":"QUERY","rcode":"NOERROR"}`
+	if string(b) != expected {
+		t.Errorf("expected response %s.\nGot %s", expected, string(b))
+	}
+
+	// a policy can reason about every record of the response
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := writeTestFile(t, dir, "dns.rego", `package dns
+
+action = "block" {
+  rr := input.response.answer[_]
+  rr.type == "A"
+  net.cidr_contains("192.0.2.2/32", rr.rdata)
+}
+`)
+	l, err := newLocal("data.dns.action", []string{policyFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.local = l
+	action, err := e.Evaluate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != policy.TypeBlock {
+		t.Errorf("expected %s, got %s", policy.NameTypes[policy.TypeBlock], policy.NameTypes[action])
+	}
+}
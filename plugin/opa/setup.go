@@ -2,13 +2,19 @@ package opa
 
 import (
 	"crypto/tls"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	pkgtls "github.com/coredns/coredns/plugin/pkg/tls"
 	"github.com/coredns/policy/plugin/pkg/rqdata"
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/keys"
 )
 
 func init() {
@@ -27,6 +33,41 @@ func setup(c *caddy.Controller) error {
 		o.next = next
 		return o
 	})
+	c.OnStartup(func() error {
+		setupMetrics(c)
+		return nil
+	})
+	for _, e := range o.engines {
+		if dl := e.decisionLog; dl != nil {
+			c.OnStartup(func() error {
+				return dl.start()
+			})
+			c.OnShutdown(func() error {
+				dl.stop()
+				return nil
+			})
+		}
+		if t := e.token; t != nil {
+			c.OnStartup(func() error {
+				t.start()
+				return nil
+			})
+			c.OnShutdown(func() error {
+				t.stop()
+				return nil
+			})
+		}
+		if l := e.local; l != nil {
+			c.OnStartup(func() error {
+				l.start()
+				return nil
+			})
+			c.OnShutdown(func() error {
+				l.stop()
+				return nil
+			})
+		}
+	}
 	return nil
 }
 
@@ -40,15 +81,109 @@ func parse(c *caddy.Controller) (*opa, error) {
 		}
 		name := args[0]
 		eng := newEngine(mapping)
+		eng.name = name
 		var tlsConfig *tls.Config
+		var query string
+		var policies, data []string
+		var fields bool
+		sample := 1.0
+		var mask [][]string
+		var bundleSrc *bundleSource
+		bundleKeys := make(map[string]*bundle.KeyConfig)
 		for c.NextBlock() {
 			switch c.Val() {
 			case "endpoint":
+				args := c.RemainingArgs()
+				if len(args) > 0 && strings.HasPrefix(args[0], unixScheme) {
+					// unix:///path/to/opa.sock PATH
+					if len(args) != 2 || !strings.HasPrefix(args[1], "/") {
+						return nil, c.Errf("endpoint %s requires the path of the policy, e.g. /v1/data/dns", args[0])
+					}
+					eng.endpoints = append(eng.endpoints, &endpoint{url: unixHost + args[1], socket: strings.TrimPrefix(args[0], unixScheme)})
+					continue
+				}
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				eng.endpoints = append(eng.endpoints, &endpoint{url: args[0]})
+			case "balance":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				if args[0] != balancePriority && args[0] != balanceRoundRobin {
+					return nil, c.Errf("invalid balance policy '%s'", args[0])
+				}
+				eng.balance = args[0]
+			case "max_fails":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n < 0 {
+					return nil, c.Errf("invalid max_fails '%s'", args[0])
+				}
+				eng.maxFails = n
+			case "fail_timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("invalid fail_timeout '%s'", args[0])
+				}
+				eng.failTimeout = d
+			case "policy":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				policies = append(policies, args...)
+			case "bundle":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				interval := defaultBundleInterval
+				if len(args) > 1 {
+					d, err := time.ParseDuration(args[1])
+					if err != nil || d <= 0 {
+						return nil, c.Errf("invalid bundle interval '%s'", args[1])
+					}
+					interval = d
+				}
+				bundleSrc = newBundleSource(args[0], interval)
+			case "bundle_key":
+				args := c.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				alg := "RS256"
+				if len(args) > 2 {
+					alg = args[2]
+				}
+				if !keys.IsSupportedAlgorithm(alg) {
+					return nil, c.Errf("unsupported bundle signing algorithm '%s'", alg)
+				}
+				b, err := ioutil.ReadFile(args[1])
+				if err != nil {
+					return nil, c.Errf("cannot read bundle key : %s", err)
+				}
+				bundleKeys[args[0]] = &bundle.KeyConfig{Key: string(b), Algorithm: alg}
+			case "data":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				data = append(data, args...)
+			case "query":
 				args := c.RemainingArgs()
 				if len(args) != 1 {
 					return nil, c.ArgErr()
 				}
-				eng.endpoint = args[0]
+				query = args[0]
 			case "fields":
 				args := c.RemainingArgs()
 				if len(args) == 0 {
@@ -56,6 +191,150 @@ func parse(c *caddy.Controller) (*opa, error) {
 				}
 				// these fields cannot be validated, because metadata fields are not known at setup time
 				eng.fields = args
+				fields = true
+			case "typed_values":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				eng.typed = true
+				fields = true
+			case "structured_input":
+				if len(c.RemainingArgs()) != 0 {
+					return nil, c.ArgErr()
+				}
+				eng.full = true
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("invalid timeout '%s'", args[0])
+				}
+				eng.timeout = d
+			case "retries":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n < 0 {
+					return nil, c.Errf("invalid number of retries '%s'", args[0])
+				}
+				eng.retries = n
+			case "max_idle_conns":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n <= 0 {
+					return nil, c.Errf("invalid number of idle connections '%s'", args[0])
+				}
+				eng.idleConns = n
+			case "keepalive":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				if args[0] == "off" {
+					eng.keepalive = 0
+					continue
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("invalid keepalive '%s'", args[0])
+				}
+				eng.keepalive = d
+			case "on_failure":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				mode, ok := failureModes[args[0]]
+				if !ok {
+					return nil, c.Errf("invalid on_failure action '%s'", args[0])
+				}
+				eng.onFailure = mode
+			case "cache":
+				args := c.RemainingArgs()
+				if len(args) > 2 {
+					return nil, c.ArgErr()
+				}
+				ttl := defaultCacheTTL
+				size := defaultCacheSize
+				if len(args) > 0 {
+					d, err := time.ParseDuration(args[0])
+					if err != nil || d <= 0 {
+						return nil, c.Errf("invalid cache TTL '%s'", args[0])
+					}
+					ttl = d
+				}
+				if len(args) > 1 {
+					n, err := strconv.Atoi(args[1])
+					if err != nil || n <= 0 {
+						return nil, c.Errf("invalid cache size '%s'", args[1])
+					}
+					size = n
+				}
+				eng.cache = newCache(ttl, size)
+			case "decision_log":
+				args := c.RemainingArgs()
+				if len(args) < 2 || len(args) > 4 {
+					return nil, c.ArgErr()
+				}
+				dl, err := parseDecisionLog(c, name, args)
+				if err != nil {
+					return nil, err
+				}
+				eng.decisionLog = dl
+			case "decision_log_sample":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				r, err := strconv.ParseFloat(args[0], 64)
+				if err != nil || r <= 0 || r > 1 {
+					return nil, c.Errf("invalid decision log sample ratio '%s'", args[0])
+				}
+				sample = r
+			case "decision_log_mask":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, f := range args {
+					p := strings.Split(strings.Trim(strings.TrimPrefix(f, "/input/"), "/"), "/")
+					if p[0] == "" {
+						return nil, c.Errf("invalid decision log mask '%s'", f)
+					}
+					mask = append(mask, p)
+				}
+			case "header":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				if eng.headers == nil {
+					eng.headers = make(map[string]string)
+				}
+				// header names are case insensitive
+				name := http.CanonicalHeaderKey(args[0])
+				if _, ok := eng.headers[name]; ok {
+					return nil, c.Errf("duplicate header %s", args[0])
+				}
+				eng.headers[name] = args[1]
+			case "bearer_token_file":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				t, err := newTokenFile(args[0])
+				if err != nil {
+					return nil, c.Err(err.Error())
+				}
+				eng.token = t
 			case "tls": // cert key cacertfile
 				args := c.RemainingArgs()
 				if len(args) == 3 {
@@ -70,11 +349,109 @@ func parse(c *caddy.Controller) (*opa, error) {
 				return nil, c.ArgErr()
 			}
 		}
-		if eng.endpoint == "" {
+		if eng.decisionLog != nil {
+			eng.decisionLog.sample = sample
+			eng.decisionLog.mask = mask
+		} else if sample != 1 || mask != nil {
+			return nil, c.Err("decision_log_sample and decision_log_mask require a decision_log")
+		}
+		if eng.full && fields {
+			return nil, c.Err("structured_input is mutually exclusive with fields and typed_values")
+		}
+		if bundleSrc != nil {
+			if len(eng.endpoints) > 0 || len(policies) > 0 || len(data) > 0 {
+				return nil, c.Err("bundle is mutually exclusive with endpoint, policy and data")
+			}
+			if query == "" {
+				return nil, c.Err("query required with bundle")
+			}
+			if len(bundleKeys) == 0 {
+				return nil, c.Err("bundle requires a bundle_key")
+			}
+			bundleSrc.verification = bundle.NewVerificationConfig(bundleKeys, "", "", nil)
+			if tlsConfig != nil {
+				bundleSrc.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+			}
+			eng.local = newBundleLocal(query, bundleSrc)
+			o.engines[name] = eng
+			continue
+		}
+		if len(bundleKeys) > 0 {
+			return nil, c.Err("bundle_key requires a bundle")
+		}
+		if len(policies) > 0 {
+			if len(eng.endpoints) > 0 {
+				return nil, c.Err("endpoint and policy are mutually exclusive")
+			}
+			if query == "" {
+				return nil, c.Err("query required with policy")
+			}
+			l, err := newLocal(query, policies, data)
+			if err != nil {
+				return nil, c.Err(err.Error())
+			}
+			eng.local = l
+			o.engines[name] = eng
+			continue
+		}
+		if query != "" || len(data) > 0 {
+			return nil, c.Err("query and data require a policy")
+		}
+		if len(eng.endpoints) == 0 {
 			return nil, c.Err("endpoint required")
 		}
-		eng.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		for _, u := range eng.endpoints {
+			if u.socket != "" && tlsConfig != nil {
+				return nil, c.Err("tls is not supported with a unix endpoint")
+			}
+			u.client = eng.newClient(u.socket, tlsConfig)
+			endpointHealthy.WithLabelValues(name, u.name()).Set(1)
+		}
 		o.engines[name] = eng
 	}
 	return o, nil
 }
+
+// parseDecisionLog parses the arguments of the decision_log option: file PATH [MAX_SIZE [BACKUPS]], or
+// http URL [BATCH_SIZE [INTERVAL]]
+func parseDecisionLog(c *caddy.Controller, engine string, args []string) (*decisionLog, error) {
+	switch args[0] {
+	case "file":
+		size, backups := defaultLogMaxSize, defaultLogBackups
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 {
+				return nil, c.Errf("invalid decision log maximum size '%s'", args[2])
+			}
+			size = n
+		}
+		if len(args) > 3 {
+			n, err := strconv.Atoi(args[3])
+			if err != nil || n < 0 {
+				return nil, c.Errf("invalid number of decision log backups '%s'", args[3])
+			}
+			backups = n
+		}
+		// the file is opened at startup, so that it is not left open if the parsing of the Corefile fails
+		return newDecisionLog(engine, newFileSink(args[1], int64(size)<<20, backups), 1, defaultLogInterval), nil
+	case "http":
+		batch, interval := defaultLogBatch, defaultLogInterval
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 {
+				return nil, c.Errf("invalid decision log batch size '%s'", args[2])
+			}
+			batch = n
+		}
+		if len(args) > 3 {
+			d, err := time.ParseDuration(args[3])
+			if err != nil || d <= 0 {
+				return nil, c.Errf("invalid decision log interval '%s'", args[3])
+			}
+			interval = d
+		}
+		s := &httpSink{url: args[1], client: &http.Client{Timeout: 10 * time.Second}}
+		return newDecisionLog(engine, s, batch, interval), nil
+	}
+	return nil, c.Errf("invalid decision log destination '%s'", args[0])
+}
@@ -1,6 +1,9 @@
 package opa
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/coredns/caddy"
@@ -26,7 +29,26 @@ func TestParse(t *testing.T) {
                   fields 1 2 3
                 }`,
 			&opa{engines: map[string]*engine{
-				"testengine": {endpoint: "test", fields: []string{"1", "2", "3"}},
+				"testengine": {endpoints: []*endpoint{{url: "test"}}, fields: []string{"1", "2", "3"}},
+			}},
+			false,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  typed_values 1
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  fields 1 2 3
+                  typed_values
+                }`,
+			&opa{engines: map[string]*engine{
+				"testengine": {endpoints: []*endpoint{{url: "test"}}, fields: []string{"1", "2", "3"}, typed: true},
 			}},
 			false,
 		},
@@ -40,11 +62,248 @@ func TestParse(t *testing.T) {
                   fields 4
                 }`,
 			&opa{engines: map[string]*engine{
-				"testengine":  {endpoint: "test", fields: []string{"1", "2", "3"}},
-				"testengine2": {endpoint: "test2", fields: []string{"4"}},
+				"testengine":  {endpoints: []*endpoint{{url: "test"}}, fields: []string{"1", "2", "3"}},
+				"testengine2": {endpoints: []*endpoint{{url: "test2"}}, fields: []string{"4"}},
+			}},
+			false,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  timeout 2s
+                  retries 2
+                  max_idle_conns 8
+                  keepalive off
+                  on_failure allow
+                }`,
+			&opa{engines: map[string]*engine{
+				"testengine": {endpoints: []*endpoint{{url: "test"}}, fields: []string{"client_ip", "name", "rcode", "response_ip"}},
+			}},
+			false,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  structured_input
+                }`,
+			&opa{engines: map[string]*engine{
+				"testengine": {endpoints: []*endpoint{{url: "test"}}, fields: []string{"client_ip", "name", "rcode", "response_ip"}, full: true},
+			}},
+			false,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  structured_input
+                  typed_values
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  cache 30s 1000
+                }`,
+			&opa{engines: map[string]*engine{
+				"testengine": {endpoints: []*endpoint{{url: "test"}}, fields: []string{"client_ip", "name", "rcode", "response_ip"}},
+			}},
+			false,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  cache 30s 0
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  cache 1m 10 100
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  decision_log http http://127.0.0.1:8181/logs 10 1s
+                  decision_log_sample 0.5
+                  decision_log_mask client_ip /input/client/ip
+                }`,
+			&opa{engines: map[string]*engine{
+				"testengine": {endpoints: []*endpoint{{url: "test"}}, fields: []string{"client_ip", "name", "rcode", "response_ip"}},
+			}},
+			false,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  decision_log syslog local0
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  decision_log http http://127.0.0.1:8181/logs 0
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  decision_log_sample 0.1
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  decision_log http http://127.0.0.1:8181/logs
+                  decision_log_sample 2
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint unix:///var/run/opa.sock /v1/data/dns
+                  header X-Tenant dns
+                }`,
+			&opa{engines: map[string]*engine{
+				"testengine": {endpoints: []*endpoint{{url: "http://unix/v1/data/dns"}}, fields: []string{"client_ip", "name", "rcode", "response_ip"}},
 			}},
 			false,
 		},
+
+		{`opa testengine {
+                  endpoint unix:///var/run/opa.sock
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  header X-Tenant
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  header X-Tenant dns
+                  header x-tenant other
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  bearer_token_file /nonexistent/token
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  endpoint unix:///var/run/opa.sock /v1/data/dns
+                  balance round_robin
+                  max_fails 0
+                  fail_timeout 30s
+                }`,
+			&opa{engines: map[string]*engine{
+				"testengine": {endpoints: []*endpoint{{url: "test"}, {url: "http://unix/v1/data/dns"}}, fields: []string{"client_ip", "name", "rcode", "response_ip"}},
+			}},
+			false,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  balance random
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  fail_timeout 0s
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  timeout 0
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  retries -1
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  max_idle_conns 0
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  keepalive forever
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  on_failure drop
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  query data.dns.action
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  endpoint test
+                  data data.json
+                }`,
+			nil,
+			true,
+		},
+
+		{`opa testengine {
+                  policy
+                }`,
+			nil,
+			true,
+		},
 	}
 
 	for i, test := range cases {
@@ -75,8 +334,22 @@ func TestParse(t *testing.T) {
 		}
 
 		for name, e := range o.engines {
-			if e.endpoint != test.expected.engines[name].endpoint {
-				t.Errorf("Test %d: engine '%s' expected endpoint %s, got %s", i, name, test.expected.engines[name].endpoint, e.endpoint)
+			if len(e.endpoints) != len(test.expected.engines[name].endpoints) {
+				t.Errorf("Test %d: engine '%s' expected %d endpoints, got %d", i, name, len(test.expected.engines[name].endpoints), len(e.endpoints))
+			} else {
+				for j, u := range e.endpoints {
+					if u.url != test.expected.engines[name].endpoints[j].url {
+						t.Errorf("Test %d: engine '%s' expected endpoint %s, got %s", i, name, test.expected.engines[name].endpoints[j].url, u.url)
+					}
+				}
+			}
+
+			if e.typed != test.expected.engines[name].typed {
+				t.Errorf("Test %d: engine '%s' expected typed %v, got %v", i, name, test.expected.engines[name].typed, e.typed)
+			}
+
+			if e.full != test.expected.engines[name].full {
+				t.Errorf("Test %d: engine '%s' expected structured input %v, got %v", i, name, test.expected.engines[name].full, e.full)
 			}
 
 			if !equal(e.fields, test.expected.engines[name].fields) {
@@ -99,3 +372,67 @@ func equal(a, b []string) bool {
 	}
 	return true
 }
+
+func TestParseLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "dns.rego")
+	if err := ioutil.WriteFile(policyFile, []byte(testPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalidFile := filepath.Join(dir, "invalid.rego")
+	if err := ioutil.WriteFile(invalidFile, []byte("package dns\naction = "), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		input     string
+		shouldErr bool
+	}{
+		{`opa testengine {
+                  policy ` + policyFile + `
+                  query data.dns.action
+                }`, false},
+		{`opa testengine {
+                  policy ` + policyFile + `
+                }`, true},
+		{`opa testengine {
+                  endpoint test
+                  policy ` + policyFile + `
+                  query data.dns.action
+                }`, true},
+		{`opa testengine {
+                  policy ` + invalidFile + `
+                  query data.dns.action
+                }`, true},
+		{`opa testengine {
+                  policy ` + filepath.Join(dir, "missing.rego") + `
+                  query data.dns.action
+                }`, true},
+		{`opa testengine {
+                  policy ` + policyFile + `
+                  data ` + policyFile + `
+                  query data.dns.action
+                }`, true},
+	}
+	for i, test := range cases {
+		o, err := parse(caddy.NewTestController("dns", test.input))
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but didn't get one for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but got one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if o.engines["testengine"].local == nil {
+			t.Errorf("Test %d: expected a local engine for input %s", i, test.input)
+		}
+	}
+}
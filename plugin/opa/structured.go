@@ -0,0 +1,157 @@
+package opa
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/pkg/response"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+	"github.com/miekg/dns"
+)
+
+// buildStructured fills the input of the policy with a structured document of the DNS message:
+// client, header, question, EDNS0 and metadata of the request, and the sections of the response if any
+func (e *engine) buildStructured(ctx context.Context, state request.Request, data input) input {
+	if _, ok := data["question"]; !ok {
+		data["client"] = map[string]interface{}{
+			"ip":          state.IP(),
+			"port":        toPort(state.Port()),
+			"protocol":    state.Proto(),
+			"server_ip":   state.LocalIP(),
+			"server_port": toPort(state.LocalPort()),
+		}
+		data["header"] = header(state.Req)
+		if len(state.Req.Question) > 0 {
+			q := state.Req.Question[0]
+			data["question"] = map[string]interface{}{
+				"name":  q.Name,
+				"type":  typeName(q.Qtype),
+				"class": className(q.Qclass),
+			}
+		}
+		if opt := state.Req.IsEdns0(); opt != nil {
+			data["edns"] = edns(opt)
+		}
+		md := make(map[string]string)
+		for label, f := range metadata.ValueFuncs(ctx) {
+			md[label] = f()
+		}
+		data["metadata"] = md
+	}
+	if rr, ok := state.W.(*response.Reader); ok && rr.Msg != nil {
+		rcode := dns.RcodeToString[rr.Msg.Rcode]
+		if rcode == "" {
+			rcode = strconv.Itoa(rr.Msg.Rcode)
+		}
+		resp := header(rr.Msg)
+		resp["rcode"] = rcode
+		resp["answer"] = records(rr.Msg.Answer)
+		resp["authority"] = records(rr.Msg.Ns)
+		resp["additional"] = records(rr.Msg.Extra)
+		if opt := rr.Msg.IsEdns0(); opt != nil {
+			resp["edns"] = edns(opt)
+		}
+		data["response"] = resp
+	}
+	return data
+}
+
+// header return the id, opcode and the flags that are set in the header of the message
+func header(m *dns.Msg) map[string]interface{} {
+	var flags []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", m.Response},
+		{"aa", m.Authoritative},
+		{"tc", m.Truncated},
+		{"rd", m.RecursionDesired},
+		{"ra", m.RecursionAvailable},
+		{"z", m.Zero},
+		{"ad", m.AuthenticatedData},
+		{"cd", m.CheckingDisabled},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	if flags == nil {
+		flags = []string{}
+	}
+	return map[string]interface{}{
+		"id":     m.Id,
+		"opcode": dns.OpcodeToString[m.Opcode],
+		"flags":  flags,
+	}
+}
+
+// edns return the EDNS0 data of the OPT record. The client subnet and cookie options are decoded,
+// other options are in the presentation format of miekg/dns
+func edns(opt *dns.OPT) map[string]interface{} {
+	options := []interface{}{}
+	d := map[string]interface{}{
+		"udp_size": opt.UDPSize(),
+		"do":       opt.Do(),
+		"version":  opt.Version(),
+	}
+	for _, o := range opt.Option {
+		options = append(options, map[string]interface{}{"code": o.Option(), "data": o.String()})
+		switch o := o.(type) {
+		case *dns.EDNS0_SUBNET:
+			d["subnet"] = map[string]interface{}{
+				"family":        o.Family,
+				"address":       o.Address.String(),
+				"source_prefix": o.SourceNetmask,
+				"scope_prefix":  o.SourceScope,
+			}
+		case *dns.EDNS0_COOKIE:
+			// the client cookie is 8 bytes, followed by the server cookie if any
+			cookie := map[string]interface{}{"client": o.Cookie, "server": ""}
+			if len(o.Cookie) > 16 {
+				cookie["client"] = o.Cookie[:16]
+				cookie["server"] = o.Cookie[16:]
+			}
+			d["cookie"] = cookie
+		}
+	}
+	d["options"] = options
+	return d
+}
+
+// records return the records of a section of a message, with their data in presentation format
+func records(rrs []dns.RR) []interface{} {
+	l := []interface{}{}
+	for _, r := range rqdata.Records(rrs) {
+		l = append(l, map[string]interface{}{
+			"name":  r.Name,
+			"type":  typeName(r.Type),
+			"class": className(r.Class),
+			"ttl":   r.TTL,
+			"rdata": r.Rdata,
+		})
+	}
+	return l
+}
+
+func typeName(t uint16) string {
+	if s, ok := dns.TypeToString[t]; ok {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func className(c uint16) string {
+	if s, ok := dns.ClassToString[c]; ok {
+		return s
+	}
+	return "CLASS" + strconv.Itoa(int(c))
+}
+
+// toPort return the port as a number, or 0 if it is not a valid port
+func toPort(s string) int {
+	p, _ := rqdata.ParsePort(s)
+	return int(p)
+}
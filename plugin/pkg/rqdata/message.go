@@ -0,0 +1,48 @@
+package rqdata
+
+import (
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// Record is a resource record of a message, with its data in presentation format
+type Record struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Rdata string
+}
+
+// Records return the records of a section of a message. The OPT record is skipped
+func Records(rrs []dns.RR) []Record {
+	l := make([]Record, 0, len(rrs))
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeOPT {
+			continue
+		}
+		l = append(l, Record{Name: h.Name, Type: h.Rrtype, Class: h.Class, TTL: h.Ttl, Rdata: Rdata(rr)})
+	}
+	return l
+}
+
+// Rdata return the presentation format of the data of the record, i.e. the record without its header
+func Rdata(rr dns.RR) string {
+	h := rr.Header().String()
+	s := rr.String()
+	if len(s) >= len(h) && s[:len(h)] == h {
+		return s[len(h):]
+	}
+	return s
+}
+
+// ParsePort return the port, and false if the string is not a valid port
+func ParsePort(s string) (uint16, bool) {
+	p, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(p), true
+}
@@ -0,0 +1,33 @@
+package rqdata
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestRecords(t *testing.T) {
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	l := Records([]dns.RR{test.A("example.com. 300 IN A 192.0.2.1"), test.MX("example.com. 60 IN MX 10 mx.example.com."), opt})
+	expected := []Record{
+		{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassINET, TTL: 300, Rdata: "192.0.2.1"},
+		{Name: "example.com.", Type: dns.TypeMX, Class: dns.ClassINET, TTL: 60, Rdata: "10 mx.example.com."},
+	}
+	if len(l) != len(expected) {
+		t.Fatalf("expected %d records, got %v", len(expected), l)
+	}
+	for i := range expected {
+		if l[i] != expected[i] {
+			t.Errorf("record %d: expected %+v, got %+v", i, expected[i], l[i])
+		}
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	for s, expected := range map[string]bool{"53": true, "65535": true, "65536": false, "-1": false, "": false} {
+		if _, ok := ParsePort(s); ok != expected {
+			t.Errorf("port %q: expected valid %v, got %v", s, expected, ok)
+		}
+	}
+}
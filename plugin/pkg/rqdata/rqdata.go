@@ -1,6 +1,7 @@
 package rqdata
 
 import (
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
@@ -11,7 +12,7 @@ import (
 	"github.com/miekg/dns"
 )
 
-type requestFunc func(state request.Request) string
+type requestFunc func(state request.Request) interface{}
 
 // Mapping define the mapping between 'name' of data and the way to extract that data from the Request
 // it also defines what will be the empty value returned if the data behind the name is empty.
@@ -34,88 +35,124 @@ func NewExtractor(r request.Request, m *Mapping) *Extractor {
 }
 
 // NewMapping build the mapping name -> func to extract data from the Request
+// each func returns the data with its natural type (see TypedValue), or nil if the data is not available
 func NewMapping(emptyValue string) *Mapping {
 	replacements := map[string]requestFunc{
-		"type": func(state request.Request) string {
+		"type": func(state request.Request) interface{} {
 			return state.Type()
 		},
-		"name": func(state request.Request) string {
+		"name": func(state request.Request) interface{} {
 			return state.Name()
 		},
-		"class": func(state request.Request) string {
+		"class": func(state request.Request) interface{} {
 			return state.Class()
 		},
-		"proto": func(state request.Request) string {
+		"proto": func(state request.Request) interface{} {
 			return state.Proto()
 		},
-		"size": func(state request.Request) string {
-			return strconv.Itoa(state.Len())
+		"size": func(state request.Request) interface{} {
+			return state.Len()
 		},
-		"client_ip": func(state request.Request) string {
-			return addrToRFC3986(state.IP())
+		"client_ip": func(state request.Request) interface{} {
+			return parseIP(state.IP())
 		},
-		"port": func(state request.Request) string {
-			return addrToRFC3986(state.Port())
+		"port": func(state request.Request) interface{} {
+			return parsePort(state.Port())
 		},
-		"rcode": func(state request.Request) string {
-			rcode := ""
+		"rcode": func(state request.Request) interface{} {
 			rr, ok := state.W.(*response.Reader)
 			if ok && rr.Msg != nil {
-				rcode = dns.RcodeToString[rr.Msg.Rcode]
+				rcode := dns.RcodeToString[rr.Msg.Rcode]
 				if rcode == "" {
 					rcode = strconv.Itoa(rr.Msg.Rcode)
 				}
+				return rcode
 			}
-			return rcode
+			return nil
 		},
-		"rsize": func(state request.Request) string {
-			rsize := ""
+		"rsize": func(state request.Request) interface{} {
 			rr, ok := state.W.(*response.Reader)
 			if ok && rr.Msg != nil {
-				rsize = strconv.Itoa(rr.Msg.Len())
+				return rr.Msg.Len()
 			}
-			return rsize
+			return nil
 		},
-		">rflags": func(state request.Request) string {
-			flags := ""
+		">rflags": func(state request.Request) interface{} {
 			rr, ok := state.W.(*response.Reader)
 			if ok && rr.Msg != nil {
-				flags = flagsToString(rr.Msg.MsgHdr)
+				return flagsToList(rr.Msg.MsgHdr)
 			}
-			return flags
+			return nil
 		},
-		">id": func(state request.Request) string {
-			return strconv.Itoa(int(state.Req.Id))
+		">id": func(state request.Request) interface{} {
+			return int(state.Req.Id)
 		},
-		">opcode": func(state request.Request) string {
-			return strconv.Itoa(int(state.Req.Opcode))
+		">opcode": func(state request.Request) interface{} {
+			return state.Req.Opcode
 		},
-		">do": func(state request.Request) string {
-			return boolToString(state.Do())
+		">do": func(state request.Request) interface{} {
+			return state.Do()
 		},
-		">bufsize": func(state request.Request) string {
-			return strconv.Itoa(state.Size())
+		">bufsize": func(state request.Request) interface{} {
+			return state.Size()
 		},
-		"server_ip": func(state request.Request) string {
-			return addrToRFC3986(state.LocalIP())
+		"server_ip": func(state request.Request) interface{} {
+			return parseIP(state.LocalIP())
 		},
-		"server_port": func(state request.Request) string {
-			return addrToRFC3986(state.LocalPort())
+		"server_port": func(state request.Request) interface{} {
+			return parsePort(state.LocalPort())
 		},
-		"response_ip": func(state request.Request) string {
+		"response_ip": func(state request.Request) interface{} {
 			rr, ok := state.W.(*response.Reader)
 			if ok && rr.Msg != nil {
 				ip := respIP(rr.Msg)
 				if ip != nil {
-					return addrToRFC3986(ip.String())
+					return ip
 				}
 			}
-			return ""
+			return nil
 		},
 	}
 	return &Mapping{replacements, emptyValue}
 }
 
+// Kinds of the values returned by TypedValue
+const (
+	KindString  = "string"
+	KindNumber  = "number"
+	KindBoolean = "boolean"
+	KindAddress = "address"
+	KindList    = "list"
+)
+
+// kinds of the typed values that are not strings
+var kinds = map[string]string{
+	"size":        KindNumber,
+	"port":        KindNumber,
+	"rsize":       KindNumber,
+	">id":         KindNumber,
+	">opcode":     KindNumber,
+	">bufsize":    KindNumber,
+	"server_port": KindNumber,
+	">do":         KindBoolean,
+	"client_ip":   KindAddress,
+	"server_ip":   KindAddress,
+	"response_ip": KindAddress,
+	">rflags":     KindList,
+}
+
+// Kind return the kind of value returned by TypedValue for this name, or false if the name is not supported
+func (m *Mapping) Kind(name string) (string, bool) {
+	if !m.ValidField(name) {
+		return "", false
+	}
+	if k, ok := kinds[name]; ok {
+		return k, true
+	}
+	return KindString, true
+}
+
+// ValidField return true if the name is a data that can be extracted from the Request
 func (m *Mapping) ValidField(name string) bool {
 	_, ok := m.replacements[name]
 	return ok
@@ -127,7 +164,7 @@ func (m *Mapping) ValidField(name string) bool {
 func (rd *Extractor) Value(name string) (string, bool) {
 	f, ok := rd.requester.replacements[name]
 	if ok {
-		v := f(rd.state)
+		v := toString(f(rd.state))
 		if v != "" {
 			return v, true
 		}
@@ -136,6 +173,66 @@ func (rd *Extractor) Value(name string) (string, bool) {
 	return "", false
 }
 
+// TypedValue extract the data that is mapped to this name and return it with its natural type:
+//   - int for "size", "port", "rsize", ">id", ">opcode", ">bufsize" and "server_port"
+//   - bool for ">do"
+//   - net.IP for "client_ip", "server_ip" and "response_ip", or a string for an address that does not parse
+//   - []string, the set of flags that are set, for ">rflags"
+//   - string for the other names
+//
+// nil is returned if the data is not available, e.g. the response data of a query
+// Second parameter is a boolean that inform if the name itself is supported in the mapping
+func (rd *Extractor) TypedValue(name string) (interface{}, bool) {
+	f, ok := rd.requester.replacements[name]
+	if !ok {
+		return nil, false
+	}
+	v := f(rd.state)
+	if s, ok := v.(string); ok && s == "" {
+		return nil, true
+	}
+	return v, true
+}
+
+// toString format a typed value as returned by the string API
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return boolToString(v)
+	case net.IP:
+		return addrToRFC3986(v.String())
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// parseIP return the IP address. An address that does not parse, e.g. the zoned address fe80::1%eth0, is returned
+// as a string as it was before the typed values, and nil is returned for an empty string
+func parseIP(s string) interface{} {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if s == "" {
+		return nil
+	}
+	return addrToRFC3986(s)
+}
+
+// parsePort return the port as an int, or nil if the string is not a valid port
+func parsePort(s string) interface{} {
+	if p, ok := ParsePort(s); ok {
+		return int(p)
+	}
+	return nil
+}
+
 func boolToString(b bool) string {
 	if b {
 		return "true"
@@ -143,10 +240,10 @@ func boolToString(b bool) string {
 	return "false"
 }
 
-// flagsToString checks all header flags and returns those
-// that are set as a string separated with commas
-func flagsToString(h dns.MsgHdr) string {
-	flags := make([]string, 7)
+// flagsToList checks all header flags and returns those
+// that are set as a list
+func flagsToList(h dns.MsgHdr) []string {
+	flags := make([]string, 8)
 	i := 0
 
 	if h.Response {
@@ -182,7 +279,7 @@ func flagsToString(h dns.MsgHdr) string {
 		flags[i] = "cd"
 		i++
 	}
-	return strings.Join(flags[:i], ",")
+	return flags[:i]
 }
 
 // addrToRFC3986 will add brackets to the address if it is an IPv6 address.
@@ -1,6 +1,8 @@
 package rqdata
 
 import (
+	"net"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -80,3 +82,110 @@ func TestNewRequestData(t *testing.T) {
 		}
 	}
 }
+
+func TestTypedValue(t *testing.T) {
+
+	mapping := NewMapping("-")
+	extractFromQuery := buildExtractorOnSimpleMsg(mapping)
+	extractFromReply := buildExtractorOnRepliedMsg(mapping)
+	tests := []struct {
+		extractor *Extractor
+		name      string
+		value     interface{}
+		error     bool
+	}{
+		{extractFromQuery, "type", "HINFO", false},
+		{extractFromQuery, "size", 29, false},
+		{extractFromQuery, "port", 40212, false},
+		{extractFromQuery, ">do", false, false},
+		{extractFromQuery, ">id", int(extractFromQuery.state.Req.Id), false},
+		{extractFromQuery, "client_ip", net.ParseIP("10.240.0.1"), false},
+		{extractFromQuery, "rcode", nil, false},
+		{extractFromQuery, "rsize", nil, false},
+		{extractFromQuery, ">rflags", nil, false},
+		{extractFromQuery, "response_ip", nil, false},
+		{extractFromQuery, "invalid", nil, true},
+		{extractFromReply, "response_ip", net.ParseIP("127.0.0.1"), false},
+		{extractFromReply, "rcode", "NOERROR", false},
+		{extractFromReply, ">rflags", []string{"qr", "rd"}, false},
+	}
+
+	for i, tst := range tests {
+		d, ok := tst.extractor.TypedValue(tst.name)
+		if !ok {
+			if !tst.error {
+				t.Errorf("Test %d, name : %s : unexpected invalid name returned", i, tst.name)
+			}
+			continue
+		}
+		if tst.error {
+			t.Errorf("Test %d, name : %s : unexpected valid name returned with value %v", i, tst.name, d)
+		}
+		if !reflect.DeepEqual(d, tst.value) {
+			t.Errorf("Test %d, name %s : valued returned : %#v, expected : %#v", i, tst.name, d, tst.value)
+		}
+	}
+}
+
+func TestValueOfIPv6(t *testing.T) {
+	w := response.NewReader(&test.ResponseWriter6{})
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	x := NewExtractor(request.Request{Req: r, W: w}, NewMapping(""))
+
+	if v, _ := x.Value("client_ip"); v != "[fe80::42:ff:feca:4c65]" {
+		t.Errorf("expected bracketed IPv6 client_ip, got %s", v)
+	}
+	if v, _ := x.TypedValue("client_ip"); !reflect.DeepEqual(v, net.ParseIP("fe80::42:ff:feca:4c65")) {
+		t.Errorf("expected IPv6 client_ip as net.IP, got %#v", v)
+	}
+}
+
+// zonedWriter is a client with a zoned IPv6 address, that is not a valid net.IP
+type zonedWriter struct {
+	test.ResponseWriter6
+}
+
+func (w *zonedWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 40212, Zone: "eth0"}
+}
+
+func TestValueOfZonedIPv6(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	x := NewExtractor(request.Request{Req: r, W: &zonedWriter{}}, NewMapping(""))
+
+	// the address is returned as it is, rather than dropped
+	if v, _ := x.Value("client_ip"); v != "[fe80::1%eth0]" {
+		t.Errorf("expected the raw client_ip, got %s", v)
+	}
+	if v, _ := x.TypedValue("client_ip"); v != "[fe80::1%eth0]" {
+		t.Errorf("expected the raw client_ip as a string, got %#v", v)
+	}
+}
+
+func TestKind(t *testing.T) {
+	mapping := NewMapping("")
+	x := buildExtractorOnRepliedMsg(mapping)
+	expected := map[string]reflect.Type{
+		KindString:  reflect.TypeOf(""),
+		KindNumber:  reflect.TypeOf(0),
+		KindBoolean: reflect.TypeOf(true),
+		KindAddress: reflect.TypeOf(net.IP{}),
+		KindList:    reflect.TypeOf([]string{}),
+	}
+	for name := range mapping.replacements {
+		k, ok := mapping.Kind(name)
+		if !ok {
+			t.Errorf("name %s : unexpected invalid name", name)
+			continue
+		}
+		v, _ := x.TypedValue(name)
+		if v != nil && reflect.TypeOf(v) != expected[k] {
+			t.Errorf("name %s : kind %s does not match the type of value %#v", name, k, v)
+		}
+	}
+	if _, ok := mapping.Kind("invalid"); ok {
+		t.Errorf("unexpected valid kind for an invalid name")
+	}
+}
@@ -0,0 +1,82 @@
+// Package watch detects the changes of files by checking periodically their modification time and size.
+package watch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// Watcher keeps the state of a set of files, and can check periodically if any of them changed
+type Watcher struct {
+	paths    []string
+	stats    []fileStat
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New return a Watcher of the files, that records their current state
+func New(interval time.Duration, paths ...string) *Watcher {
+	w := &Watcher{paths: paths, stats: make([]fileStat, len(paths)), interval: interval}
+	w.Changed()
+	return w
+}
+
+// Changed return true if any of the files changed since the last call, or since the Watcher was created.
+// A file that is created or removed is also a change
+func (w *Watcher) Changed() bool {
+	changed := false
+	for i, p := range w.paths {
+		var s fileStat
+		if st, err := os.Stat(p); err == nil {
+			s = fileStat{st.ModTime(), st.Size(), true}
+		}
+		if s != w.stats[i] {
+			w.stats[i] = s
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Start calls onChange each time a change of the files is detected, until Stop is called.
+// Nothing is watched if the interval is not positive
+func (w *Watcher) Start(onChange func()) {
+	if w.interval <= 0 || w.stop != nil {
+		return
+	}
+	w.stop = make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				if w.Changed() {
+					onChange()
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the watch of the files, and waits for the current call of onChange to return
+func (w *Watcher) Stop() {
+	if w.stop != nil {
+		close(w.stop)
+		w.wg.Wait()
+		w.stop = nil
+	}
+}
@@ -0,0 +1,91 @@
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	if err := ioutil.WriteFile(a, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := New(time.Second, a, b)
+	if w.Changed() {
+		t.Errorf("expected no change of the files just after the creation of the watcher")
+	}
+
+	// content of same size, with a new modification time
+	if err := ioutil.WriteFile(a, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(a, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Errorf("expected a change of the modification time to be detected")
+	}
+	if w.Changed() {
+		t.Errorf("expected a change to be reported only once")
+	}
+
+	// file created
+	if err := ioutil.WriteFile(b, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Errorf("expected the creation of a file to be detected")
+	}
+
+	// file removed
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Errorf("expected the removal of a file to be detected")
+	}
+}
+
+func TestStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a")
+	if err := ioutil.WriteFile(a, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan struct{}, 1)
+	w := New(10*time.Millisecond, a)
+	w.Start(func() { changes <- struct{}{} })
+	defer w.Stop()
+
+	if err := ioutil.WriteFile(a, []byte("longer content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the change of the file to be notified")
+	}
+
+	// no watch without a positive interval
+	nw := New(0, a)
+	nw.Start(func() { t.Errorf("unexpected notification of a change") })
+	nw.Stop()
+}
@@ -0,0 +1,98 @@
+package starlark
+
+import (
+	"strconv"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+	"github.com/coredns/policy/plugin/pkg/watch"
+)
+
+func init() {
+	caddy.RegisterPlugin("starlark", caddy.Plugin{
+		ServerType: "dns",
+		Action:     setup,
+	})
+}
+
+func setup(c *caddy.Controller) error {
+	p, err := parse(c)
+	if err != nil {
+		return plugin.Error("starlark", err)
+	}
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		p.next = next
+		return p
+	})
+	for _, e := range p.engines {
+		e := e
+		c.OnStartup(func() error {
+			e.start()
+			return nil
+		})
+		c.OnShutdown(func() error {
+			e.stop()
+			return nil
+		})
+	}
+	return nil
+}
+
+func parse(c *caddy.Controller) (*starlark, error) {
+	p := newStarlark()
+	mapping := rqdata.NewMapping("")
+	for c.Next() {
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		name := args[0]
+		if _, ok := p.engines[name]; ok {
+			return nil, c.Errf("starlark engine %s is already declared", name)
+		}
+		e := newEngine(mapping)
+		for c.NextBlock() {
+			switch c.Val() {
+			case "script":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				e.path = args[0]
+			case "set":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				if _, ok := e.sets[args[0]]; ok {
+					return nil, c.Errf("set %s is already declared", args[0])
+				}
+				e.sets[args[0]] = args[1]
+			case "max_steps":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil || n == 0 {
+					return nil, c.Errf("invalid number of steps '%s'", args[0])
+				}
+				e.maxSteps = n
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+		if e.path == "" {
+			return nil, c.Err("script required")
+		}
+		// the state of the script and the sets is recorded first, a change during the load is then reloaded
+		e.files = watch.New(ReloadInterval, e.paths()...)
+		if err := e.load(); err != nil {
+			return nil, c.Err(err.Error())
+		}
+		p.engines[name] = e
+	}
+	return p, nil
+}
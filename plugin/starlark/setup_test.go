@@ -0,0 +1,123 @@
+package starlark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starlark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.star")
+	writeFile(t, valid, "def on_query(req):\n    return 'allow'\n")
+	invalid := filepath.Join(dir, "invalid.star")
+	writeFile(t, invalid, "def on_query(req)\n")
+	// a script without the functions of the engine
+	empty := filepath.Join(dir, "empty.star")
+	writeFile(t, empty, "x = 1\n")
+	// on_response must have the request and the response as parameters
+	params := filepath.Join(dir, "params.star")
+	writeFile(t, params, "def on_response(req):\n    return None\n")
+	// the top-level statements are limited by the steps too
+	loop := filepath.Join(dir, "loop.star")
+	writeFile(t, loop, "def f():\n    while True:\n        pass\nf()\ndef on_query(req):\n    return None\n")
+	set := filepath.Join(dir, "set.txt")
+	writeFile(t, set, "example.org.\n")
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		engines   []string
+		maxSteps  uint64
+	}{
+		{`starlark`, true, nil, 0},
+		{`starlark myengine`, true, nil, 0},
+		{`starlark myengine {
+			script ` + valid + `
+		}`, false, []string{"myengine"}, 100000},
+		{`starlark myengine {
+			script ` + valid + `
+			set blocked ` + set + `
+			max_steps 500
+		}`, false, []string{"myengine"}, 500},
+		{`starlark myengine {
+			script ` + valid + `
+			max_steps 0
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + valid + `
+			set blocked ` + set + `
+			set blocked ` + set + `
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + valid + `
+			set blocked ` + filepath.Join(dir, "missing.txt") + `
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + valid + `
+			unknown
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + invalid + `
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + empty + `
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + params + `
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + loop + `
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + filepath.Join(dir, "missing.star") + `
+		}`, true, nil, 0},
+		{`starlark myengine {
+			script ` + valid + `
+		}
+		starlark other {
+			script ` + valid + `
+		}`, false, []string{"myengine", "other"}, 100000},
+		{`starlark myengine {
+			script ` + valid + `
+		}
+		starlark myengine {
+			script ` + valid + `
+		}`, true, nil, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		p, err := parse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but didn't get one for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but got one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if len(p.engines) != len(test.engines) {
+			t.Errorf("Test %d: expected %d engines, got %d", i, len(test.engines), len(p.engines))
+		}
+		for _, name := range test.engines {
+			e := p.engines[name]
+			if e == nil {
+				t.Errorf("Test %d: expected engine %s to be declared", i, name)
+				continue
+			}
+			if e.maxSteps != test.maxSteps {
+				t.Errorf("Test %d: expected %d steps, got %d", i, test.maxSteps, e.maxSteps)
+			}
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// Package starlark implements a policy engine for the firewall plugin where the policy is a Starlark script,
+// evaluated in-process.
+package starlark
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+	"github.com/coredns/policy/plugin/pkg/watch"
+
+	"github.com/miekg/dns"
+	gostarlark "go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// ReloadInterval is the period used to check the script and set files for changes
+var ReloadInterval = 5 * time.Second
+
+// starlark is a policy engine plugin for the firewall plugin that evaluates Starlark scripts
+type starlark struct {
+	engines map[string]*engine
+	next    plugin.Handler
+}
+
+// engine evaluates the DNS requests and replies with the functions of a Starlark script.
+// The script and the sets are reloaded whenever one of the files changes on disk.
+type engine struct {
+	path     string
+	sets     map[string]string // name of the set -> file of its entries
+	maxSteps uint64            // maximum number of execution steps of one evaluation
+	mapping  *rqdata.Mapping   // store this so we dont have to rebuild it for every request
+	files    *watch.Watcher
+
+	sync.RWMutex
+	current *script
+}
+
+// script is one load of the script file and of the sets. Its globals are frozen, so the functions
+// can be called concurrently
+type script struct {
+	onQuery    gostarlark.Callable
+	onResponse gostarlark.Callable
+}
+
+// input is the data of the functions of the script for one request
+type input struct {
+	req   gostarlark.Value
+	resp  gostarlark.Value // nil when evaluating a query
+	reply bool
+}
+
+// field is an attribute of the req or resp parameters, and the field of the request it is extracted from
+type field struct {
+	name  string
+	field string
+}
+
+var requestFields = []field{
+	{"type", "type"},
+	{"name", "name"},
+	{"class", "class"},
+	{"proto", "proto"},
+	{"size", "size"},
+	{"client_ip", "client_ip"},
+	{"port", "port"},
+	{"server_ip", "server_ip"},
+	{"server_port", "server_port"},
+	{"id", ">id"},
+	{"opcode", ">opcode"},
+	{"do", ">do"},
+	{"bufsize", ">bufsize"},
+}
+
+var responseFields = []field{
+	{"rcode", "rcode"},
+	{"size", "rsize"},
+	{"flags", ">rflags"},
+	{"ip", "response_ip"},
+}
+
+// fileOptions are the dialect of the scripts: while loops and recursion are allowed, as execution steps are limited
+var fileOptions = &syntax.FileOptions{Set: true, While: true, Recursion: true}
+
+func newStarlark() *starlark {
+	return &starlark{engines: make(map[string]*engine)}
+}
+
+func newEngine(m *rqdata.Mapping) *engine {
+	return &engine{
+		mapping:  m,
+		sets:     make(map[string]string),
+		maxSteps: 100000,
+	}
+}
+
+// Name implements the Handler interface
+func (p *starlark) Name() string { return "starlark" }
+
+// ServeDNS implements the Handler interface
+func (p *starlark) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	// do nothing
+	return plugin.NextOrFailure(p.Name(), p.next, ctx, w, r)
+}
+
+// Engine implements the policy.Engineer interface
+func (p *starlark) Engine(name string) policy.Engine {
+	return p.engines[name]
+}
+
+// BuildQueryData implements the policy.Engine interface
+func (e *engine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
+	extractor := rqdata.NewExtractor(state, e.mapping)
+	d := fieldsDict(extractor, requestFields)
+	md := gostarlark.NewDict(0)
+	for label, f := range metadata.ValueFuncs(ctx) {
+		md.SetKey(gostarlark.String(label), gostarlark.String(f()))
+	}
+	d["metadata"] = md
+	req := starlarkstruct.FromStringDict(starlarkstruct.Default, d)
+	req.Freeze()
+	return &input{req: req}, nil
+}
+
+// BuildReplyData implements the policy.Engine interface
+func (e *engine) BuildReplyData(ctx context.Context, state request.Request, queryData interface{}) (interface{}, error) {
+	q, ok := queryData.(*input)
+	if !ok {
+		d, err := e.BuildQueryData(ctx, state)
+		if err != nil {
+			return nil, err
+		}
+		q = d.(*input)
+	}
+	extractor := rqdata.NewExtractor(state, e.mapping)
+	resp := starlarkstruct.FromStringDict(starlarkstruct.Default, fieldsDict(extractor, responseFields))
+	resp.Freeze()
+	return &input{req: q.req, resp: resp, reply: true}, nil
+}
+
+// BuildRule implements the policy.Engine interface
+func (e *engine) BuildRule(args []string) (policy.Rule, error) { return e, nil }
+
+// Evaluate implements the policy.Rule interface
+func (e *engine) Evaluate(data interface{}) (int, error) {
+	in, ok := data.(*input)
+	if !ok {
+		return policy.TypeRefuse, fmt.Errorf("evaluation of starlark script %s - data provided are of wrong type", e.path)
+	}
+	e.RLock()
+	s := e.current
+	e.RUnlock()
+
+	fn, args := s.onQuery, gostarlark.Tuple{in.req}
+	if in.reply {
+		fn, args = s.onResponse, gostarlark.Tuple{in.req, in.resp}
+	}
+	if fn == nil {
+		return policy.TypeNone, nil
+	}
+	thread := newThread(e.path)
+	thread.SetMaxExecutionSteps(e.maxSteps)
+	v, err := gostarlark.Call(thread, fn, args, nil)
+	if err != nil {
+		return policy.TypeRefuse, fmt.Errorf("evaluation of starlark script %s return an error : %s", e.path, err)
+	}
+	return toAction(v)
+}
+
+// load compiles the script file and reads the sets, and replaces the previous script only if it succeeds
+func (e *engine) load() error {
+	predeclared := gostarlark.StringDict{
+		"incidr": gostarlark.NewBuiltin("incidr", incidr),
+	}
+	sets := gostarlark.NewDict(len(e.sets))
+	for name, path := range e.sets {
+		set, err := readSet(path)
+		if err != nil {
+			return fmt.Errorf("cannot read set %s : %s", name, err)
+		}
+		sets.SetKey(gostarlark.String(name), set)
+	}
+	predeclared["sets"] = sets
+	predeclared.Freeze()
+
+	b, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return fmt.Errorf("cannot read starlark script %s : %s", e.path, err)
+	}
+	_, prog, err := gostarlark.SourceProgramOptions(fileOptions, e.path, b, predeclared.Has)
+	if err != nil {
+		return fmt.Errorf("cannot compile starlark script %s : %s", e.path, err)
+	}
+	thread := newThread(e.path)
+	thread.SetMaxExecutionSteps(e.maxSteps)
+	globals, err := prog.Init(thread, predeclared)
+	if err != nil {
+		return fmt.Errorf("cannot execute starlark script %s : %s", e.path, err)
+	}
+	// concurrent evaluations must not modify the state of the script
+	globals.Freeze()
+
+	s := &script{}
+	if s.onQuery, err = function(globals, "on_query", 1); err != nil {
+		return fmt.Errorf("invalid starlark script %s : %s", e.path, err)
+	}
+	if s.onResponse, err = function(globals, "on_response", 2); err != nil {
+		return fmt.Errorf("invalid starlark script %s : %s", e.path, err)
+	}
+	if s.onQuery == nil && s.onResponse == nil {
+		return fmt.Errorf("invalid starlark script %s : on_query or on_response must be defined", e.path)
+	}
+
+	e.Lock()
+	e.current = s
+	e.Unlock()
+	return nil
+}
+
+func (e *engine) reload() {
+	if err := e.load(); err != nil {
+		log.Printf("[ERROR] Keeping previous starlark script: %s", err)
+		return
+	}
+	log.Printf("[INFO] Reloaded starlark script %s", e.path)
+}
+
+// start watches the script and set files and reload them on change, until stop is called
+func (e *engine) start() { e.files.Start(e.reload) }
+
+func (e *engine) stop() { e.files.Stop() }
+
+// paths return the files of the script and of the sets
+func (e *engine) paths() []string {
+	paths := []string{e.path}
+	for _, p := range e.sets {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// newThread return a thread that cannot load other modules, and logs the output of print
+func newThread(name string) *gostarlark.Thread {
+	return &gostarlark.Thread{
+		Name: name,
+		Print: func(_ *gostarlark.Thread, msg string) {
+			log.Printf("[INFO] starlark %s: %s", name, msg)
+		},
+	}
+}
+
+// function return the global function with this name, or nil if it is not defined
+func function(globals gostarlark.StringDict, name string, params int) (gostarlark.Callable, error) {
+	v, ok := globals[name]
+	if !ok {
+		return nil, nil
+	}
+	fn, ok := v.(*gostarlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a function, not %s", name, v.Type())
+	}
+	if fn.NumParams() != params {
+		return nil, fmt.Errorf("%s must have %d parameters, not %d", name, params, fn.NumParams())
+	}
+	return fn, nil
+}
+
+// toAction convert the value returned by a function of the script into an action.
+// None is no action, otherwise the name of an action is expected
+func toAction(v gostarlark.Value) (int, error) {
+	if v == gostarlark.None {
+		return policy.TypeNone, nil
+	}
+	if s, ok := v.(gostarlark.String); ok {
+		for k, n := range policy.NameTypes {
+			if string(s) == n {
+				return k, nil
+			}
+		}
+	}
+	return policy.TypeRefuse, fmt.Errorf("unknown action returned by starlark script: %s", v)
+}
+
+// fieldsDict return the values of the fields extracted from the request.
+// Data that is not available is None
+func fieldsDict(extractor *rqdata.Extractor, fields []field) gostarlark.StringDict {
+	d := make(gostarlark.StringDict, len(fields))
+	for _, f := range fields {
+		tv, _ := extractor.TypedValue(f.field)
+		d[f.name] = toStarlarkValue(tv)
+	}
+	return d
+}
+
+// toStarlarkValue convert a typed value of the request into a Starlark value
+func toStarlarkValue(v interface{}) gostarlark.Value {
+	switch v := v.(type) {
+	case string:
+		return gostarlark.String(v)
+	case int:
+		return gostarlark.MakeInt(v)
+	case bool:
+		return gostarlark.Bool(v)
+	case net.IP:
+		return gostarlark.String(v.String())
+	case []string:
+		l := make([]gostarlark.Value, len(v))
+		for i, s := range v {
+			l[i] = gostarlark.String(s)
+		}
+		return gostarlark.NewList(l)
+	}
+	return gostarlark.None
+}
+
+// readSet return the set of the lines of the file. Empty lines and comments starting with # are ignored
+func readSet(path string) (*gostarlark.Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	set := gostarlark.NewSet(0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			set.Insert(gostarlark.String(line))
+		}
+	}
+	return set, scanner.Err()
+}
+
+// incidr returns true if the IP address is in the subnet
+func incidr(_ *gostarlark.Thread, b *gostarlark.Builtin, args gostarlark.Tuple, kwargs []gostarlark.Tuple) (gostarlark.Value, error) {
+	var ip, cidr string
+	if err := gostarlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &ip, &cidr); err != nil {
+		return nil, err
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("%s: first argument is not an IP address: %s", b.Name(), ip)
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	return gostarlark.Bool(subnet.Contains(addr)), nil
+}
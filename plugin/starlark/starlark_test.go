@@ -0,0 +1,179 @@
+package starlark
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/response"
+
+	"github.com/miekg/dns"
+)
+
+const testScript = `
+def in_domains(name, domains):
+    labels = name.rstrip('.').split('.')
+    for i in range(len(labels)):
+        if '.'.join(labels[i:]) + '.' in domains:
+            return True
+    return False
+
+def on_query(req):
+    if req.metadata.get('test/group') == 'admin':
+        return 'allow'
+    if in_domains(req.name, sets['blocked']):
+        return 'block'
+    if req.type == 'TXT' and not incidr(req.client_ip, '192.168.0.0/16'):
+        return 'refuse'
+    if req.type == 'MX':
+        while True:
+            pass
+    if req.type == 'NS':
+        return 'unknown'
+    return None
+
+def on_response(req, resp):
+    if resp.rcode == 'NOERROR' and resp.ip == '192.0.2.1':
+        return 'drop'
+    return 'allow'
+`
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestEngine(t *testing.T, dir string, script string) *engine {
+	path := filepath.Join(dir, "policy.star")
+	writeFile(t, path, script)
+	blocked := filepath.Join(dir, "blocked.txt")
+	writeFile(t, blocked, "# blocked domains\nexample.org.\n\nexample.net. # and subdomains\n")
+
+	p, err := parse(caddy.NewTestController("dns", `starlark myengine {
+		script `+path+`
+		set blocked `+blocked+`
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.engines["myengine"]
+}
+
+func TestEvaluate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starlark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := newTestEngine(t, dir, testScript)
+
+	tests := []struct {
+		name      string
+		qtype     uint16
+		group     string
+		answer    string
+		expected  int
+		shouldErr bool
+	}{
+		{"www.example.org.", dns.TypeA, "", "", policy.TypeBlock, false},
+		{"example.net.", dns.TypeA, "", "", policy.TypeBlock, false},
+		{"example.net.", dns.TypeA, "admin", "", policy.TypeAllow, false},
+		{"example.com.", dns.TypeA, "", "", policy.TypeNone, false},
+		{"example.com.", dns.TypeTXT, "", "", policy.TypeRefuse, false},
+		{"example.com.", dns.TypeMX, "", "", 0, true},
+		{"example.com.", dns.TypeNS, "", "", 0, true},
+		{"example.com.", dns.TypeA, "", "example.com. IN A 192.0.2.1", policy.TypeDrop, false},
+		{"example.com.", dns.TypeA, "", "example.com. IN A 192.0.2.2", policy.TypeAllow, false},
+	}
+	for i, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tc.name, tc.qtype)
+		state := request.Request{W: &test.ResponseWriter{}, Req: r}
+
+		ctx := metadata.ContextWithMetadata(context.TODO())
+		if tc.group != "" {
+			metadata.SetValueFunc(ctx, "test/group", func() string { return tc.group })
+		}
+		data, err := e.BuildQueryData(ctx, state)
+		if err != nil {
+			t.Fatalf("Test %d: unexpected error at build query data : %s", i, err)
+		}
+		if tc.answer != "" {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = append(m.Answer, test.A(tc.answer))
+			data, err = e.BuildReplyData(ctx, request.Request{W: &response.Reader{Msg: m}, Req: r}, data)
+			if err != nil {
+				t.Fatalf("Test %d: unexpected error at build reply data : %s", i, err)
+			}
+		}
+
+		result, err := e.Evaluate(data)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error at evaluate", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error at evaluate : %s", i, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[tc.expected], policy.NameTypes[result])
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starlark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e := newTestEngine(t, dir, "def on_query(req):\n    return 'allow'\n")
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	data, err := e.BuildQueryData(context.TODO(), request.Request{W: &test.ResponseWriter{}, Req: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := e.Evaluate(data); err != nil || result != policy.TypeAllow {
+		t.Fatalf("expected allow before reload, got %s, error : %v", policy.NameTypes[result], err)
+	}
+
+	writeFile(t, e.path, "def on_query(req):\n    return 'drop'\n")
+	if err := e.load(); err != nil {
+		t.Fatalf("unexpected error at reload : %s", err)
+	}
+	if result, err := e.Evaluate(data); err != nil || result != policy.TypeDrop {
+		t.Errorf("expected drop after reload, got %s, error : %v", policy.NameTypes[result], err)
+	}
+
+	// a broken script keeps the previous one active
+	writeFile(t, e.path, "def on_query(req)\n")
+	if err := e.load(); err == nil {
+		t.Errorf("expected an error at reload of an invalid script")
+	}
+	if result, err := e.Evaluate(data); err != nil || result != policy.TypeDrop {
+		t.Errorf("expected drop to be kept after failed reload, got %s, error : %v", policy.NameTypes[result], err)
+	}
+
+	// responses have no action if on_response is not defined
+	data, err = e.BuildReplyData(context.TODO(), request.Request{W: &test.ResponseWriter{}, Req: r}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := e.Evaluate(data); err != nil || result != policy.TypeNone {
+		t.Errorf("expected no action for a response, got %s, error : %v", policy.NameTypes[result], err)
+	}
+}
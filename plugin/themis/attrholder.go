@@ -13,6 +13,9 @@ import (
 	"github.com/coredns/policy/plugin/firewall/policy"
 	rq "github.com/coredns/policy/plugin/pkg/rqdata"
 	"github.com/infobloxopen/go-trees/domain"
+	"github.com/infobloxopen/go-trees/domaintree"
+	"github.com/infobloxopen/go-trees/iptree"
+	"github.com/infobloxopen/go-trees/strtree"
 	"github.com/infobloxopen/themis/pdp"
 	"github.com/miekg/dns"
 )
@@ -39,8 +42,11 @@ type attrHolder struct {
 
 	dnstap []pdp.AttributeAssignment
 
+	qtype  uint16
 	action byte
-	dst    string
+	dst    string // destination of the redirect_to obligation
+
+	debug *debugInfo // set for a debug query
 }
 
 func init() {
@@ -61,17 +67,14 @@ func newAttrHolderWithContext(ctx context.Context, xtr *rq.Extractor, optMap []*
 		panic(fmt.Errorf("Can't treat %q as domain name: %s", qName, err))
 	}
 
-	clientIP, _ := xtr.Value("client_ip")
-	var srcIP = net.IP(nil)
-	if clientIP != "" {
-		srcIP = net.ParseIP(clientIP)
-	}
+	srcIP, _ := typedIP(xtr, "client_ip")
 	if srcIP != nil {
 		hdrCount++
 	}
 
 	ah := &attrHolder{
 		dn:    qName,
+		qtype: dns.StringToType[qType],
 		dnReq: make([]pdp.AttributeAssignment, hdrCount, 8),
 	}
 
@@ -84,15 +87,33 @@ func newAttrHolderWithContext(ctx context.Context, xtr *rq.Extractor, optMap []*
 	}
 
 	for _, o := range optMap {
-		f := metadata.ValueFunc(ctx, o.label)
-		if f == nil {
-			continue
-		}
-		value := f()
-		if value == "" {
+		var (
+			a  pdp.AttributeAssignment
+			ok bool
+		)
+		if f := metadata.ValueFunc(ctx, o.label); f != nil {
+			// metadata, that takes precedence over the data of the request with the same name
+			value := f()
+			if value == "" {
+				continue
+			}
+			a, ok = makeAssignmentByType(o, value)
+		} else if ip, valid := typedIP(xtr, o.label); valid && strings.ToLower(o.attrType) == "address" {
+			// data of the request, as an address
+			if ip == nil {
+				continue
+			}
+			a, ok = pdp.MakeAddressAssignment(o.name, ip), true
+		} else if value, valid := xtr.Value(o.label); valid {
+			// data of the request, as a string
+			if value == "" {
+				continue
+			}
+			a, ok = makeAssignmentByType(o, value)
+		} else {
 			continue
 		}
-		if a, ok := makeAssignmentByType(o, value); ok {
+		if ok {
 			if o.name == attrNameSourceIP && srcIP != nil {
 				ah.dnReq[3] = a
 			} else {
@@ -107,18 +128,72 @@ func newAttrHolderWithContext(ctx context.Context, xtr *rq.Extractor, optMap []*
 	return ah
 }
 
+// typedIP return the IP address of the request data with this name, or nil if that data is not an address.
+// Second parameter is a boolean that inform if the name is a data of the request
+func typedIP(xtr *rq.Extractor, name string) (net.IP, bool) {
+	v, ok := xtr.TypedValue(name)
+	ip, _ := v.(net.IP)
+	return ip, ok
+}
+
+// makeAssignmentByType return the assignment of the value to the attribute, converted to the type of the attribute.
+// An invalid value is logged and not assigned
 func makeAssignmentByType(o *attrSetting, value string) (pdp.AttributeAssignment, bool) {
-	switch strings.ToLower(o.attrType) {
-	case "address":
-		return pdp.MakeAddressAssignment(o.name, net.ParseIP(value)), true
-	case "string":
-		return pdp.MakeStringAssignment(o.name, value), true
+	t, ok := allowedAttrTypes[strings.ToLower(o.attrType)]
+	if !ok {
+		log.Printf("[ERROR] Unknown type %s of attribute %q", o.attrType, o.name)
+		return pdp.AttributeAssignment{}, false
 	}
-	panic(fmt.Errorf("unknown attribute type %s", o.attrType))
+	v, err := makeValueByType(t, value)
+	if err != nil {
+		log.Printf("[ERROR] Can't assign %q to attribute %q of type %s: %s", value, o.name, o.attrType, err)
+		return pdp.AttributeAssignment{}, false
+	}
+	return pdp.MakeExpressionAssignment(o.name, v), true
+}
+
+// makeValueByType converts the value to the type. The values of a collection are separated by commas
+func makeValueByType(t pdp.Type, value string) (pdp.AttributeValue, error) {
+	switch t {
+	case pdp.TypeSetOfStrings:
+		ss := strtree.NewTree()
+		for i, s := range strings.Split(value, ",") {
+			ss.InplaceInsert(s, i)
+		}
+		return pdp.MakeSetOfStringsValue(ss), nil
+
+	case pdp.TypeSetOfNetworks:
+		sn := iptree.NewTree()
+		for i, s := range strings.Split(value, ",") {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return pdp.UndefinedValue, err
+			}
+			sn.InplaceInsertNet(n, i)
+		}
+		return pdp.MakeSetOfNetworksValue(sn), nil
+
+	case pdp.TypeSetOfDomains:
+		sd := &domaintree.Node{}
+		for i, s := range strings.Split(value, ",") {
+			d, err := domain.MakeNameFromString(s)
+			if err != nil {
+				return pdp.UndefinedValue, err
+			}
+			sd.InplaceInsert(d, i)
+		}
+		return pdp.MakeSetOfDomainsValue(sd), nil
+
+	case pdp.TypeListOfStrings:
+		return pdp.MakeListOfStringsValue(strings.Split(value, ",")), nil
+	}
+
+	return pdp.MakeValueFromString(t, value)
 }
 
 func (ah *attrHolder) addDnRes(r *pdp.Response, custAttrs map[string]custAttr) {
 	oCount := len(r.Obligations)
+	ah.dst = ""
 
 	switch r.Effect {
 	default:
@@ -173,6 +248,9 @@ func (ah *attrHolder) addDnRes(r *pdp.Response, custAttrs map[string]custAttr) {
 			case attrNameRefuse:
 				ah.action = policy.TypeRefuse
 
+			case attrNameRedirectTo:
+				ah.addRedirect(o)
+
 			case attrNameDrop:
 				ah.action = policy.TypeDrop
 			}
@@ -207,12 +285,8 @@ func (ah *attrHolder) putCustomAttr(attr pdp.AttributeAssignment, f custAttr) {
 }
 
 func (ah *attrHolder) prepareResponseFromContext(ctx context.Context, xtr *rq.Extractor) {
-	ipResp, _ := xtr.Value("response_ip")
-	if ipResp != "" {
-		ip := net.ParseIP(ipResp)
-		if ip != nil {
-			ah.addIPReq(ip)
-		}
+	if ip, _ := typedIP(xtr, "response_ip"); ip != nil {
+		ah.addIPReq(ip)
 	}
 }
 
@@ -227,6 +301,7 @@ func (ah *attrHolder) addIPReq(ip net.IP) {
 }
 
 func (ah *attrHolder) addIPRes(r *pdp.Response) {
+	ah.dst = ""
 	switch r.Effect {
 	default:
 		log.Printf("[ERROR] PDP Effect: %s, Reason: %s", pdp.EffectNameFromEnum(r.Effect), r.Status)
@@ -250,8 +325,8 @@ func (ah *attrHolder) addIPRes(r *pdp.Response) {
 			case attrNameRefuse:
 				ah.action = policy.TypeRefuse
 
-			//case attrNameRedirectTo:
-			//	ah.addRedirect(o)
+			case attrNameRedirectTo:
+				ah.addRedirect(o)
 
 			case attrNameDrop:
 				ah.action = policy.TypeDrop
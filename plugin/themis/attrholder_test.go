@@ -88,6 +88,103 @@ func TestNewAttrHolderWithDnReq(t *testing.T) {
 	}, "Can't treat %q as domain name: %s", "...", domain.ErrEmptyLabel)
 }
 
+func TestNewAttrHolderWithIPv6Client(t *testing.T) {
+	optsMap := []*attrSetting{
+		{"server", "server_ip", "Address", false},
+		{"qname", "name", "String", false},
+	}
+
+	state := buildState("example.com.", dns.TypeA, "2001:db8::1")
+	state.W.(*fakeWriter).serverIP = "2001:db8::53"
+	ctx := buildContext(context.TODO(), map[string]string{})
+
+	ah := newAttrHolderWithContext(ctx, rqdata.NewExtractor(state, rqdata.NewMapping("")), optsMap, nil)
+	pdp.AssertAttributeAssignments(t, "newAttrHolderWithIPv6Client", ah.dnReq,
+		pdp.MakeStringAssignment(attrNameType, typeValueQuery),
+		pdp.MakeDomainAssignment(attrNameDomainName, makeTestDomain(dns.Fqdn("example.com"))),
+		pdp.MakeStringAssignment(attrNameDNSQtype, strconv.FormatUint(uint64(dns.TypeA), 16)),
+		pdp.MakeAddressAssignment(attrNameSourceIP, net.ParseIP("2001:db8::1")),
+		pdp.MakeAddressAssignment("server", net.ParseIP("2001:db8::53")),
+		pdp.MakeStringAssignment("qname", "example.com."),
+	)
+}
+
+func TestNewAttrHolderWithMetadataCollision(t *testing.T) {
+	optsMap := []*attrSetting{
+		{"server", "server_ip", "Address", false},
+		{"qname", "name", "String", false},
+		{"proto", "proto", "String", false},
+	}
+
+	state := buildState("example.com.", dns.TypeA, "192.0.2.1")
+	state.W.(*fakeWriter).serverIP = "192.0.2.53"
+	// metadata with the same label as a field of the request takes precedence
+	ctx := buildContext(context.TODO(), map[string]string{
+		"server_ip": "198.51.100.53",
+		"name":      "example.org.",
+		"proto":     "",
+	})
+
+	ah := newAttrHolderWithContext(ctx, rqdata.NewExtractor(state, rqdata.NewMapping("")), optsMap, nil)
+	pdp.AssertAttributeAssignments(t, "newAttrHolderWithMetadataCollision", ah.dnReq,
+		pdp.MakeStringAssignment(attrNameType, typeValueQuery),
+		pdp.MakeDomainAssignment(attrNameDomainName, makeTestDomain(dns.Fqdn("example.com"))),
+		pdp.MakeStringAssignment(attrNameDNSQtype, strconv.FormatUint(uint64(dns.TypeA), 16)),
+		pdp.MakeAddressAssignment(attrNameSourceIP, net.ParseIP("192.0.2.1")),
+		pdp.MakeAddressAssignment("server", net.ParseIP("198.51.100.53")),
+		pdp.MakeStringAssignment("qname", "example.org."),
+	)
+}
+
+func TestMakeAssignmentByType(t *testing.T) {
+	tests := []struct {
+		attrType string
+		value    string
+		ok       bool
+		typeKey  string
+		expected string
+	}{
+		{"string", "abc", true, "string", "abc"},
+		{"Domain", "example.com", true, "domain", "example.com"},
+		{"domain", "...", false, "", ""},
+		{"address", "2001:db8::1", true, "address", "2001:db8::1"},
+		{"address", "not an address", false, "", ""},
+		{"integer", "42", true, "integer", "42"},
+		{"integer", "4.2", false, "", ""},
+		{"float", "4.2", true, "float", "4.2"},
+		{"float", "four", false, "", ""},
+		{"boolean", "true", true, "boolean", "true"},
+		{"boolean", "yes", false, "", ""},
+		{"network", "192.0.2.0/24", true, "network", "192.0.2.0/24"},
+		{"network", "192.0.2.1", false, "", ""},
+		{"set_of_strings", "a,b", true, "set of strings", "\"a\",\"b\""},
+		{"set_of_networks", "192.0.2.0/24,2001:db8::/32", true, "set of networks", "\"192.0.2.0/24\",\"2001:db8::/32\""},
+		{"set_of_networks", "192.0.2.0/24,192.0.2.1", false, "", ""},
+		{"set_of_domains", "example.com,example.org", true, "set of domains", "\"example.com\",\"example.org\""},
+		{"set_of_domains", "example.com,...", false, "", ""},
+		{"list_of_strings", "b,a", true, "list of strings", "\"b\",\"a\""},
+	}
+
+	for _, test := range tests {
+		a, ok := makeAssignmentByType(&attrSetting{"attr", "label", test.attrType, false}, test.value)
+		if ok != test.ok {
+			t.Errorf("%s %q: expected ok=%v, got %v", test.attrType, test.value, test.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		_, typeKey, v, err := a.Serialize(emptyCtx)
+		if err != nil {
+			t.Errorf("%s %q: can't serialize assignment: %s", test.attrType, test.value, err)
+			continue
+		}
+		if typeKey != test.typeKey || v != test.expected {
+			t.Errorf("%s %q: expected %s %q, got %s %q", test.attrType, test.value, test.typeKey, test.expected, typeKey, v)
+		}
+	}
+}
+
 func TestAddIpReq(t *testing.T) {
 
 	optsMap := []*attrSetting{
@@ -161,17 +258,35 @@ func TestActionDomainResponse(t *testing.T) {
 			},
 			action: policy.TypeBlock,
 		},
-		//{
-		//	res: &pdp.Response{
-		//		Effect: pdp.EffectDeny,
-		//		Obligations: []pdp.AttributeAssignment{
-		//			pdp.MakeStringAssignment(attrNameRedirectTo, "192.0.2.1"),
-		//		},
-		//	},
-		//	action: firewall.TypeRedirect,
-		//	dst:    "192.0.2.1",
-		//},
-		/* attrNameRedirectTo is not currently implemented AFAICT
+		{
+			res: &pdp.Response{
+				Effect: pdp.EffectDeny,
+				Obligations: []pdp.AttributeAssignment{
+					pdp.MakeStringAssignment(attrNameRedirectTo, "192.0.2.1"),
+				},
+			},
+			action: policy.TypeBlock,
+			dst:    "192.0.2.1",
+		},
+		{
+			res: &pdp.Response{
+				Effect: pdp.EffectDeny,
+				Obligations: []pdp.AttributeAssignment{
+					pdp.MakeDomainAssignment(attrNameRedirectTo, makeTestDomain("redirect.example.net")),
+				},
+			},
+			action: policy.TypeBlock,
+			dst:    "redirect.example.net",
+		},
+		{
+			res: &pdp.Response{
+				Effect: pdp.EffectDeny,
+				Obligations: []pdp.AttributeAssignment{
+					pdp.MakeStringAssignment(attrNameRedirectTo, "not a domain"),
+				},
+			},
+			action: policy.TypeBlock,
+		},
 		{
 			res: &pdp.Response{
 				Effect: pdp.EffectDeny,
@@ -179,9 +294,8 @@ func TestActionDomainResponse(t *testing.T) {
 					pdp.MakeIntegerAssignment(attrNameRedirectTo, 0),
 				},
 			},
-			action: policy.TypeNone,
+			action: policy.TypeBlock,
 		},
-		*/
 		{
 			res: &pdp.Response{
 				Effect: pdp.EffectDeny,
@@ -275,17 +389,17 @@ func TestActionIpResponse(t *testing.T) {
 			initAction: policy.TypeAllow,
 			action:     policy.TypeBlock,
 		},
-		//{
-		//	res: &pdp.Response{
-		//		Effect: pdp.EffectDeny,
-		//		Obligations: []pdp.AttributeAssignment{
-		//			pdp.MakeStringAssignment(attrNameRedirectTo, "192.0.2.1"),
-		//		},
-		//	},
-		//	initAction: policy.TypeAllow,
-		//	action:     policy.TypeRedirect,
-		//	dst:        "192.0.2.1",
-		//},
+		{
+			res: &pdp.Response{
+				Effect: pdp.EffectDeny,
+				Obligations: []pdp.AttributeAssignment{
+					pdp.MakeAddressAssignment(attrNameRedirectTo, net.ParseIP("2001:db8::1")),
+				},
+			},
+			initAction: policy.TypeAllow,
+			action:     policy.TypeBlock,
+			dst:        "2001:db8::1",
+		},
 		{
 			res: &pdp.Response{
 				Effect: pdp.EffectDeny,
@@ -773,6 +887,14 @@ func TestMakeDnstapReport(t *testing.T) {
 		pdp.MakeStringAssignment("dnstap", "dnstapVal"),
 	)
 
+	setDnstapMetadata(ctx, "NAME", ah)
+	f := metadata.ValueFunc(ctx, "themis/dnstap_NAME")
+	if f == nil {
+		t.Fatalf("expected metadata %q", "themis/dnstap_NAME")
+	}
+	if extra := f(); extra != `{"dnstap":"dnstapVal"}` {
+		t.Errorf("expected dnstap extra %q but got %q", `{"dnstap":"dnstapVal"}`, extra)
+	}
 }
 
 func makeTestDomain(s string) domain.Name {
@@ -56,7 +56,11 @@ func (p *ThemisEngine) connect() error {
 	//}
 
 	if p.conf.policyFile != "" {
-		p.pdp = client.NewBuiltinClient(p.conf.policyFile, p.conf.contentFiles)
+		c := client.NewBuiltinClient(p.conf.policyFile, p.conf.contentFiles, p.reloaded)
+		if p.conf.updatesDir != "" {
+			c.SetContentUpdates(p.conf.updatesDir, p.updated)
+		}
+		p.pdp = c
 	} else {
 		p.pdp = pep.NewClient(opts...)
 	}
@@ -64,6 +68,24 @@ func (p *ThemisEngine) connect() error {
 	return p.pdp.Connect("")
 }
 
+// reloaded counts the reloads of the policy of the builtin PDP client
+func (p *ThemisEngine) reloaded(err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	policyReloads.WithLabelValues(p.name, status).Inc()
+}
+
+// updated counts the content updates applied by the builtin PDP client
+func (p *ThemisEngine) updated(err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	contentUpdates.WithLabelValues(p.name, status).Inc()
+}
+
 // closeConn terminates previously established connection.
 func (p *ThemisEngine) closeConn() {
 	if p.pdp != nil {
@@ -97,6 +119,10 @@ func (p *ThemisEngine) validate(ah *attrHolder, a []pdp.AttributeAssignment) err
 		log.Printf("[INFO] PDP response: %+v", res)
 	}
 
+	if ah.debug != nil {
+		ah.debug.record(p.conf.debugID, req, &res)
+	}
+
 	if len(ah.ipReq) > 0 {
 		ah.addIPRes(&res)
 	} else {
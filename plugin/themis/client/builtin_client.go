@@ -5,7 +5,12 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/coredns/policy/plugin/pkg/watch"
+	"github.com/google/uuid"
 	"github.com/infobloxopen/themis/pdp"
 	"github.com/infobloxopen/themis/pdp/ast"
 	"github.com/infobloxopen/themis/pdp/jcon"
@@ -13,21 +18,43 @@ import (
 	_ "github.com/infobloxopen/themis/pdp/selector"
 )
 
+// ReloadInterval is the period used to check the policy and content files for changes
+var ReloadInterval = 5 * time.Second
+
+// storage is the policy and the content used together to validate a request
+type storage struct {
+	p *pdp.PolicyStorage
+	c *pdp.LocalContentStorage
+}
+
 type builtinClient struct {
 	policyFile   string
 	contentFiles []string
 
 	parser ast.Parser
 
-	p *pdp.PolicyStorage
-	c *pdp.LocalContentStorage
+	s atomic.Value // *storage, replaced as a whole on reload
+
+	files    *watch.Watcher
+	onReload func(err error)
+
+	updatesDir string       // directory of the content updates, if any
+	applied    *updateState // content updates applied to the current storage
+	updates    *watch.Watcher
+	onUpdate   func(err error)
+
+	loading sync.Mutex // serializes the reloads and the content updates
 }
 
-func NewBuiltinClient(policyFile string, contentFiles []string) *builtinClient {
+// NewBuiltinClient return a client that validates requests with the policy and content files. The files are
+// reloaded when they change, and onReload, if not nil, is called with the result of each reload
+func NewBuiltinClient(policyFile string, contentFiles []string, onReload func(err error)) *builtinClient {
 	c := &builtinClient{
 		policyFile:   policyFile,
 		contentFiles: contentFiles,
+		onReload:     onReload,
 	}
+	c.s.Store(&storage{})
 	return c
 }
 
@@ -43,28 +70,28 @@ func (c *builtinClient) setPolicyParser() {
 	}
 }
 
-func (c *builtinClient) loadPolicies() error {
+func (c *builtinClient) loadPolicies() (*pdp.PolicyStorage, error) {
 	log.Printf("[INFO] Loading policy '%s'", c.policyFile)
 	c.setPolicyParser()
 
 	pf, err := os.Open(c.policyFile)
 	if err != nil {
 		log.Printf("[ERROR] Failed to open policy file: %s", err)
-		return err
+		return nil, err
 	}
+	defer pf.Close()
 
 	log.Printf("[INFO] Parsing policy '%s'", c.policyFile)
 	p, err := c.parser.Unmarshal(pf, nil)
 	if err != nil {
 		log.Printf("[ERROR] Failed to parse policy: %s", err)
-		return err
+		return nil, err
 	}
 
-	c.p = p
-	return nil
+	return p, nil
 }
 
-func (c *builtinClient) loadContents() error {
+func (c *builtinClient) loadContents() (*pdp.LocalContentStorage, error) {
 	log.Print("[INFO] Loading content")
 
 	var items []*pdp.LocalContent
@@ -80,7 +107,9 @@ func (c *builtinClient) loadContents() error {
 			defer f.Close()
 
 			log.Printf("[INFO] Parsing content '%s'", path)
-			item, err := jcon.Unmarshal(f, nil)
+			// the initial version of a content is the nil UUID, the content updates change it
+			tag := uuid.Nil
+			item, err := jcon.Unmarshal(f, &tag)
 			if err != nil {
 				log.Printf("[ERROR] Failed to parse content: %s", err)
 				return err
@@ -90,29 +119,100 @@ func (c *builtinClient) loadContents() error {
 			return nil
 		}()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	c.c = pdp.NewLocalContentStorage(items)
+	return pdp.NewLocalContentStorage(items), nil
+}
+
+// load parses the policy and content files
+func (c *builtinClient) load() (*storage, error) {
+	p, err := c.loadPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	lc, err := c.loadContents()
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage{p: p, c: lc}, nil
+}
+
+// activate loads the files, applies the content updates, and replaces the policy and content
+func (c *builtinClient) activate() error {
+	s, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	applied := newUpdateState()
+	if c.updatesDir != "" {
+		s = c.applyUpdates(s, applied)
+	}
+
+	c.s.Store(s)
+	c.applied = applied
 	return nil
 }
 
 func (c *builtinClient) Connect(addr string) error {
-	if err := c.loadPolicies(); err != nil {
-		return err
+	// the state of the files is recorded before they are read, so that a change while reading is reloaded
+	files := watch.New(ReloadInterval, append([]string{c.policyFile}, c.contentFiles...)...)
+	var updates *watch.Watcher
+	if c.updatesDir != "" {
+		updates = watch.New(ReloadInterval, c.updatesDir)
 	}
 
-	if err := c.loadContents(); err != nil {
+	c.loading.Lock()
+	err := c.activate()
+	c.loading.Unlock()
+	if err != nil {
 		return err
 	}
 
+	if c.files == nil {
+		c.files = files
+		c.files.Start(c.reload)
+	}
+	if updates != nil && c.updates == nil {
+		c.updates = updates
+		c.updates.Start(c.update)
+	}
+
 	return nil
 }
 
+// reload parses the files again, and replaces the policy and content only if all of them are valid.
+// The content updates are applied again to the new content. The requests being validated keep the
+// previous policy and content
+func (c *builtinClient) reload() {
+	c.loading.Lock()
+	err := c.activate()
+	c.loading.Unlock()
+	if err != nil {
+		log.Printf("[ERROR] Keeping previous policy '%s': %s", c.policyFile, err)
+	} else {
+		log.Printf("[INFO] Reloaded policy '%s'", c.policyFile)
+	}
+
+	if c.onReload != nil {
+		c.onReload(err)
+	}
+}
+
 func (c *builtinClient) Close() {
-	c.p = nil
-	c.c = nil
+	if c.files != nil {
+		c.files.Stop()
+		c.files = nil
+	}
+	if c.updates != nil {
+		c.updates.Stop()
+		c.updates = nil
+	}
+	c.s.Store(&storage{})
 }
 
 func (c *builtinClient) Validate(in, out interface{}) error {
@@ -125,7 +225,12 @@ func (c *builtinClient) Validate(in, out interface{}) error {
 		return fmt.Errorf("unknown response type passed to Validate()")
 	}
 
-	ctx, err := pdp.NewContext(c.c, len(req), func(i int) (string, pdp.AttributeValue, error) {
+	s := c.s.Load().(*storage)
+	if s.p == nil {
+		return fmt.Errorf("policy is not loaded")
+	}
+
+	ctx, err := pdp.NewContext(s.c, len(req), func(i int) (string, pdp.AttributeValue, error) {
 		id := req[i].GetID()
 		v, err := req[i].GetValue()
 		return id, v, err
@@ -134,7 +239,7 @@ func (c *builtinClient) Validate(in, out interface{}) error {
 		return fmt.Errorf("error creating pdp context '%s'", err)
 	}
 
-	r := c.p.Root().Calculate(ctx)
+	r := s.p.Root().Calculate(ctx)
 
 	res.Effect = r.Effect
 	res.Status = r.Status
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/infobloxopen/go-trees/domain"
+	"github.com/infobloxopen/themis/pdp"
+)
+
+const testPolicy = `attributes:
+  domain_name: domain
+
+policies:
+  alg: FirstApplicableEffect
+  rules:
+  - target:
+    - contains:
+      - val:
+          type: set of domains
+          content:
+          - example.com
+      - attr: domain_name
+    effect: %s
+`
+
+func writePolicy(t *testing.T, path, effect string) {
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(testPolicy, effect)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuiltinClientReload(t *testing.T) {
+	defer func(interval time.Duration) { ReloadInterval = interval }(ReloadInterval)
+	ReloadInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "themis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := filepath.Join(dir, "policy.yaml")
+	writePolicy(t, policyFile, "Permit")
+
+	reloads := make(chan error, 10)
+	c := NewBuiltinClient(policyFile, nil, func(err error) { reloads <- err })
+	if err := c.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	dn, err := domain.MakeNameFromString("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func() int {
+		res := pdp.Response{}
+		if err := c.Validate([]pdp.AttributeAssignment{pdp.MakeDomainAssignment("domain_name", dn)}, &res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Effect
+	}
+	waitReload := func() error {
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("policy was not reloaded")
+		}
+		return nil
+	}
+
+	if effect := validate(); effect != pdp.EffectPermit {
+		t.Errorf("expected effect %s, got %s", pdp.EffectNameFromEnum(pdp.EffectPermit), pdp.EffectNameFromEnum(effect))
+	}
+
+	// a valid policy replaces the previous one
+	writePolicy(t, policyFile, "Deny")
+	if err := waitReload(); err != nil {
+		t.Fatalf("unexpected reload error: %s", err)
+	}
+	if effect := validate(); effect != pdp.EffectDeny {
+		t.Errorf("expected effect %s, got %s", pdp.EffectNameFromEnum(pdp.EffectDeny), pdp.EffectNameFromEnum(effect))
+	}
+
+	// an invalid policy is not activated
+	writePolicy(t, policyFile, "Unknown effect")
+	if err := waitReload(); err == nil {
+		t.Fatal("expected a reload error for an invalid policy")
+	}
+	if effect := validate(); effect != pdp.EffectDeny {
+		t.Errorf("expected effect %s, got %s", pdp.EffectNameFromEnum(pdp.EffectDeny), pdp.EffectNameFromEnum(effect))
+	}
+}
+
+const testContentPolicy = `attributes:
+  domain_name: domain
+  category: string
+
+policies:
+  alg: FirstApplicableEffect
+  rules:
+  - id: Malware
+    condition:
+      equal:
+      - selector:
+          uri: "local:categories/domains"
+          path:
+          - attr: domain_name
+          type: string
+      - val:
+          type: string
+          content: malware
+    effect: Deny
+    obligations:
+    - category:
+        val:
+          type: string
+          content: malware
+  - id: Phishing
+    condition:
+      equal:
+      - selector:
+          uri: "local:categories/domains"
+          path:
+          - attr: domain_name
+          type: string
+      - val:
+          type: string
+          content: phishing
+    effect: Deny
+    obligations:
+    - category:
+        val:
+          type: string
+          content: phishing
+  - id: Default
+    effect: Permit
+`
+
+const testContent = `{
+  "id": "categories",
+  "items": {
+    "domains": {
+      "keys": ["domain"],
+      "type": "string",
+      "data": {
+        "example.com": "clean",
+        "example.org": "clean"
+      }
+    }
+  }
+}`
+
+const testContentUpdate = `{
+  "id": "categories",
+  "from": "%s",
+  "to": "%s",
+  "commands": [
+    {
+      "op": "Add",
+      "path": ["domains", "%s"],
+      "entity": {"type": "string", "data": "%s"}
+    }
+  ]
+}`
+
+func TestBuiltinClientContentUpdates(t *testing.T) {
+	defer func(interval time.Duration) { ReloadInterval = interval }(ReloadInterval)
+	ReloadInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "themis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(policyFile, []byte(testContentPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	contentFile := filepath.Join(dir, "content.json")
+	if err := ioutil.WriteFile(contentFile, []byte(testContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	updatesDir := filepath.Join(dir, "updates")
+	if err := os.Mkdir(updatesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUpdate := func(name, from, to, domain, category string) {
+		u := fmt.Sprintf(testContentUpdate, from, to, domain, category)
+		if err := ioutil.WriteFile(filepath.Join(updatesDir, name), []byte(u), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v1 := "6a1b2c3d-0000-4000-8000-000000000001"
+	v2 := "6a1b2c3d-0000-4000-8000-000000000002"
+	// the first update is applied when the client connects
+	writeUpdate("001.json", "00000000-0000-0000-0000-000000000000", v1, "example.com", "malware")
+
+	updates := make(chan error, 10)
+	c := NewBuiltinClient(policyFile, []string{contentFile}, nil)
+	c.SetContentUpdates(updatesDir, func(err error) { updates <- err })
+	if err := c.Connect(""); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	category := func(name string) string {
+		dn, err := domain.MakeNameFromString(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := pdp.Response{}
+		if err := c.Validate([]pdp.AttributeAssignment{pdp.MakeDomainAssignment("domain_name", dn)}, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Effect == pdp.EffectPermit {
+			return "clean"
+		}
+		if len(res.Obligations) != 1 {
+			t.Fatalf("expected the category obligation, got %s %v", pdp.EffectNameFromEnum(res.Effect), res.Status)
+		}
+		v, err := res.Obligations[0].GetValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := v.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	waitUpdate := func() error {
+		select {
+		case err := <-updates:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("content update was not applied")
+		}
+		return nil
+	}
+
+	if err := waitUpdate(); err != nil {
+		t.Fatalf("unexpected update error: %s", err)
+	}
+	if v := category("example.com"); v != "malware" {
+		t.Errorf("expected category malware for example.com, got %q", v)
+	}
+
+	// an update of a version the content does not have yet waits for it
+	v3 := "6a1b2c3d-0000-4000-8000-000000000003"
+	writeUpdate("003.json", v2, v3, "example.org", "malware")
+	select {
+	case err := <-updates:
+		t.Fatalf("expected the update to wait, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if v := category("example.org"); v != "clean" {
+		t.Errorf("expected category clean for example.org, got %q", v)
+	}
+
+	// the next version is applied, leaving the other entries untouched, and then the waiting update
+	writeUpdate("002.json", v1, v2, "example.org", "phishing")
+	for i := 0; i < 2; i++ {
+		if err := waitUpdate(); err != nil {
+			t.Fatalf("unexpected update error: %s", err)
+		}
+	}
+	if v := category("example.org"); v != "malware" {
+		t.Errorf("expected category malware for example.org, got %q", v)
+	}
+	if v := category("example.com"); v != "malware" {
+		t.Errorf("expected category malware for example.com, got %q", v)
+	}
+
+	// an update to a version the content already had is skipped, an update from a past version fails once
+	writeUpdate("004.json", v1, v2, "example.org", "malware")
+	writeUpdate("005.json", v2, "6a1b2c3d-0000-4000-8000-000000000004", "example.org", "malware")
+	if err := waitUpdate(); err == nil {
+		t.Fatal("expected an error for an update of a past version")
+	}
+	writeUpdate("006.json", v3, "6a1b2c3d-0000-4000-8000-000000000005", "example.com", "phishing")
+	if err := waitUpdate(); err != nil {
+		t.Fatalf("unexpected update error: %s", err)
+	}
+	if v := category("example.org"); v != "malware" {
+		t.Errorf("expected category malware for example.org, got %q", v)
+	}
+	if v := category("example.com"); v != "phishing" {
+		t.Errorf("expected category phishing for example.com, got %q", v)
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/infobloxopen/themis/pdp"
+	"github.com/infobloxopen/themis/pdp/jcon"
+)
+
+// contentUpdate is a patch file of a content. The commands are applied to the content only if its version is the
+// from tag, and change its version to the to tag
+type contentUpdate struct {
+	ID       string          `json:"id"`
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Commands json.RawMessage `json:"commands"`
+
+	from, to uuid.UUID
+}
+
+// SetContentUpdates enables the content updates: the patch files *.json of the directory are applied in the order
+// of their names. onUpdate, if not nil, is called with the result of each patch. It must be called before Connect
+func (c *builtinClient) SetContentUpdates(dir string, onUpdate func(err error)) {
+	c.updatesDir = dir
+	c.onUpdate = onUpdate
+}
+
+// updateFiles return the patch files of the directory, sorted by name
+func (c *builtinClient) updateFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.updatesDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// updateState is the state of the content updates applied to a content storage
+type updateState struct {
+	versions map[string]uuid.UUID          // current version of each content, by content id
+	included map[string]map[uuid.UUID]bool // previous versions of each content
+	done     map[string]bool               // patch files applied, already included or failed for good
+}
+
+func newUpdateState() *updateState {
+	return &updateState{
+		versions: make(map[string]uuid.UUID),
+		included: make(map[string]map[uuid.UUID]bool),
+		done:     make(map[string]bool),
+	}
+}
+
+// includes return true if the content is or was at the version
+func (st *updateState) includes(id string, v uuid.UUID) bool {
+	return st.versions[id] == v || st.included[id][v]
+}
+
+// setVersion records the new version of the content
+func (st *updateState) setVersion(id string, v uuid.UUID) {
+	if st.included[id] == nil {
+		st.included[id] = make(map[uuid.UUID]bool)
+	}
+	st.included[id][st.versions[id]] = true
+	st.versions[id] = v
+}
+
+// applyUpdates applies the patch files that are not applied yet to the content. A patch whose target version is
+// already included in the content is skipped. A patch of a version the content does not have yet is kept for
+// later, it may follow another patch. Any other patch that fails is logged and reported once
+func (c *builtinClient) applyUpdates(s *storage, st *updateState) *storage {
+	files, err := c.updateFiles()
+	if err != nil {
+		log.Printf("[ERROR] Failed to list content updates in '%s': %s", c.updatesDir, err)
+		return s
+	}
+
+	// the patches are applied in the order of their names, until none of the remaining ones can be applied
+	for progress := true; progress; {
+		progress = false
+		for _, path := range files {
+			if st.done[path] {
+				continue
+			}
+
+			u, err := readUpdate(path)
+			if err == nil {
+				if st.includes(u.ID, u.to) {
+					log.Printf("[INFO] Skipping content update '%s': content '%s' already includes version %s", path, u.ID, u.to)
+					st.done[path] = true
+					continue
+				}
+				if v := st.versions[u.ID]; u.from != v {
+					if !st.includes(u.ID, u.from) {
+						// the patch may apply after another one
+						continue
+					}
+					err = fmt.Errorf("content '%s' is at version %s, past %s", u.ID, v, u.from)
+				}
+			}
+
+			if err == nil {
+				var lc *pdp.LocalContentStorage
+				if lc, err = applyUpdate(s.c, u); err == nil {
+					log.Printf("[INFO] Applied content update '%s' to content '%s': version %s -> %s", path, u.ID, u.from, u.to)
+					s = &storage{p: s.p, c: lc}
+					st.setVersion(u.ID, u.to)
+					progress = true
+				}
+			}
+
+			st.done[path] = true
+			if err != nil {
+				log.Printf("[ERROR] Failed to apply content update '%s': %s", path, err)
+			}
+			if c.onUpdate != nil {
+				c.onUpdate(err)
+			}
+		}
+	}
+
+	for _, path := range files {
+		if !st.done[path] {
+			log.Printf("[INFO] Content update '%s' waits for another version of its content", path)
+		}
+	}
+
+	return s
+}
+
+// readUpdate reads and parses the patch file
+func readUpdate(path string) (*contentUpdate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var u contentUpdate
+	if err := json.Unmarshal(b, &u); err != nil {
+		return nil, err
+	}
+	if u.from, err = uuid.Parse(u.From); err != nil {
+		return nil, fmt.Errorf("invalid from tag: %s", err)
+	}
+	if u.to, err = uuid.Parse(u.To); err != nil {
+		return nil, fmt.Errorf("invalid to tag: %s", err)
+	}
+	return &u, nil
+}
+
+// applyUpdate applies the patch to the content storage in a transaction, and return the new storage
+func applyUpdate(lc *pdp.LocalContentStorage, u *contentUpdate) (*pdp.LocalContentStorage, error) {
+	t, err := lc.NewTransaction(u.ID, &u.from)
+	if err != nil {
+		return nil, err
+	}
+
+	cu, err := jcon.UnmarshalUpdate(bytes.NewReader(u.Commands), u.ID, u.from, u.to, t.Symbols())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.Apply(cu); err != nil {
+		return nil, err
+	}
+
+	return t.Commit(lc)
+}
+
+// update applies the new patch files of the directory
+func (c *builtinClient) update() {
+	c.loading.Lock()
+	defer c.loading.Unlock()
+
+	s := c.s.Load().(*storage)
+	if s.p == nil {
+		return
+	}
+	c.s.Store(c.applyUpdates(s, c.applied))
+}
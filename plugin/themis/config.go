@@ -3,28 +3,44 @@ package themis
 import (
 	"errors"
 	"math"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/infobloxopen/themis/pdp"
+	"github.com/miekg/dns"
 )
 
 var errInvalidOption = errors.New("invalid themis plugin option")
 
-var allowedAttrTypes = map[string]string{
-	"string":  "string",
-	"domain":  "domain",
-	"address": "address"}
+// allowedAttrTypes are the PDP types of the attr option. The values of the collections are separated by commas
+var allowedAttrTypes = map[string]pdp.Type{
+	"string":          pdp.TypeString,
+	"domain":          pdp.TypeDomain,
+	"address":         pdp.TypeAddress,
+	"integer":         pdp.TypeInteger,
+	"float":           pdp.TypeFloat,
+	"boolean":         pdp.TypeBoolean,
+	"network":         pdp.TypeNetwork,
+	"set_of_strings":  pdp.TypeSetOfStrings,
+	"set_of_networks": pdp.TypeSetOfNetworks,
+	"set_of_domains":  pdp.TypeSetOfDomains,
+	"list_of_strings": pdp.TypeListOfStrings,
+}
 
 type config struct {
 	policyFile   string
 	contentFiles []string
+	updatesDir   string
 	endpoints    []string
 	options      []*attrSetting
 	custAttrs    map[string]custAttr
 	debugID      string
 	debugSuffix  string
+	debugNets    []*net.IPNet
 	streams      int
 	hotSpot      bool
 	connTimeout  time.Duration
@@ -49,11 +65,16 @@ func themisParse(c *caddy.Controller) (*ThemisPlugin, error) {
 			return nil, c.Errf("themis plugin with engine name %s is already declared", name)
 		}
 		p := newThemisEngine()
+		p.name = name
 		for c.NextBlock() {
 			if err := p.conf.parseOption(c); err != nil {
 				return nil, err
 			}
 		}
+		p.dnstap = p.conf.hasDnstap()
+		if p.conf.updatesDir != "" && p.conf.policyFile == "" {
+			return nil, c.Errf("content_updates requires a local pdp policy")
+		}
 		tp.engines[name] = p
 	}
 	return tp, nil
@@ -67,6 +88,9 @@ func (conf *config) parseOption(c *caddy.Controller) error {
 	case "endpoint":
 		return conf.parseEndpoint(c)
 
+	case "content_updates":
+		return conf.parseContentUpdates(c)
+
 	case "attr":
 		return conf.parseAttr(c)
 
@@ -82,6 +106,9 @@ func (conf *config) parseOption(c *caddy.Controller) error {
 	case "metrics":
 		return conf.parseAttributes(c, custAttrMetrics)
 
+	case "dnstap":
+		return conf.parseAttributes(c, custAttrDnstap)
+
 	case "debug_id":
 		return conf.parseDebugID(c)
 
@@ -119,6 +146,17 @@ func (conf *config) parsePDP(c *caddy.Controller) error {
 	return nil
 }
 
+// Usage: content_updates directory
+func (conf *config) parseContentUpdates(c *caddy.Controller) error {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return c.ArgErr()
+	}
+
+	conf.updatesDir = args[0]
+	return nil
+}
+
 func (conf *config) parseEndpoint(c *caddy.Controller) error {
 	args := c.RemainingArgs()
 	if len(args) <= 0 {
@@ -149,6 +187,7 @@ func (conf *config) parseAttr(c *caddy.Controller) error {
 		for k := range allowedAttrTypes {
 			tp = append(tp, k)
 		}
+		sort.Strings(tp)
 		return c.Errf("invalid type %s for an attribute - allowed types are : %s", dataType, strings.Join(tp, ","))
 	}
 	conf.options = append(conf.options, &attrSetting{name, label, dataType, false})
@@ -203,13 +242,34 @@ func (conf *config) parseStreams(c *caddy.Controller) error {
 	return nil
 }
 
+// Usage: debug_query_suffix suffix [network...]
 func (conf *config) parseDebugQuerySuffix(c *caddy.Controller) error {
 	args := c.RemainingArgs()
-	if len(args) != 1 {
+	if len(args) < 1 {
 		return c.ArgErr()
 	}
 
-	conf.debugSuffix = args[0]
+	conf.debugSuffix = dns.Fqdn(strings.ToLower(args[0]))
+
+	nets := args[1:]
+	if len(nets) == 0 {
+		nets = defaultDebugNets
+	}
+	conf.debugNets = make([]*net.IPNet, 0, len(nets))
+	for _, s := range nets {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return c.Errf("Could not parse debug query network: %s", err)
+		}
+		conf.debugNets = append(conf.debugNets, n)
+	}
 	return nil
 }
 
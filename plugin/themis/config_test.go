@@ -19,6 +19,7 @@ func TestThemisConfigParse(t *testing.T) {
 		endpoints    []string
 		options      []*attrSetting
 		debugSuffix  *string
+		debugNets    []string
 		streams      *int
 		hotSpot      *bool
 		custAttrs    map[string]custAttr
@@ -111,6 +112,24 @@ func TestThemisConfigParse(t *testing.T) {
 				"ip":  custAttrEdns,
 			},
 		},
+		{
+			desc: "AttrTypes",
+			input: `.:53 {
+						themis NAME {
+							endpoint 10.2.4.1:5555
+							attr count request/count integer
+							attr subnets request/subnets set_of_networks
+						}
+					}`,
+			options: []*attrSetting{
+				{"count", "request/count", "integer", false},
+				{"subnets", "request/subnets", "set_of_networks", false},
+			},
+			custAttrs: map[string]custAttr{
+				"count":   custAttrEdns,
+				"subnets": custAttrEdns,
+			},
+		},
 		{
 			desc: "AttrWithNoLabel",
 			input: `.:53 {
@@ -140,6 +159,28 @@ func TestThemisConfigParse(t *testing.T) {
 						}
 					}`,
 			debugSuffix: newStringPtr("debug.local."),
+			debugNets:   []string{"127.0.0.0/8", "::1/128"},
+		},
+		{
+			desc: "DebugQuerySuffixWithNetworks",
+			input: `.:53 {
+						themis NAME {
+							endpoint 10.2.4.1:5555
+							debug_query_suffix Debug.Local 10.0.0.0/8 2001:db8::/32 192.0.2.1
+						}
+					}`,
+			debugSuffix: newStringPtr("debug.local."),
+			debugNets:   []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1/32"},
+		},
+		{
+			desc: "InvalidDebugQueryNetwork",
+			input: `.:53 {
+						themis NAME {
+							endpoint 10.2.4.1:5555
+							debug_query_suffix debug.local. 10.0.0.0/33
+						}
+					}`,
+			err: errors.New("Could not parse debug query network"),
 		},
 		{
 			desc: "PDPClientStreams",
@@ -225,6 +266,59 @@ func TestThemisConfigParse(t *testing.T) {
 				"themis_id": custAttrTransfer,
 			},
 		},
+		{
+			desc: "DnstapAttributes",
+			input: `.:53 {
+						themis NAME {
+							endpoint 10.2.4.1:5555
+							transfer themis_id
+							dnstap themis_id policy_id
+						}
+					}`,
+			custAttrs: map[string]custAttr{
+				"themis_id": custAttrTransfer | custAttrDnstap,
+				"policy_id": custAttrDnstap,
+			},
+		},
+		{
+			desc: "NoDnstapArguments",
+			input: `.:53 {
+						themis NAME {
+							endpoint 10.2.4.1:5555
+							dnstap
+						}
+					}`,
+			err: errors.New("Wrong argument count or unexpected line ending"),
+		},
+		{
+			desc: "ContentUpdates",
+			input: `.:53 {
+						themis NAME {
+							pdp policy.yaml content.json
+							content_updates /var/lib/themis/updates
+						}
+					}`,
+		},
+		{
+			desc: "ContentUpdatesWithoutPDP",
+			input: `.:53 {
+						themis NAME {
+							endpoint 10.2.4.1:5555
+							content_updates /var/lib/themis/updates
+						}
+					}`,
+			err: errors.New("content_updates requires a local pdp policy"),
+		},
+		{
+			desc: "NoContentUpdatesArguments",
+			input: `.:53 {
+						themis NAME {
+							pdp policy.yaml content.json
+							content_updates
+						}
+					}`,
+			err: errors.New("Wrong argument count or unexpected line ending"),
+		},
 		{
 			desc: "ComplexAttributeConfig",
 			input: `.:53 {
@@ -628,6 +722,16 @@ func TestThemisConfigParse(t *testing.T) {
 							t.Errorf("Expected debug suffix %q but got %q", *test.debugSuffix, mwe.conf.debugSuffix)
 						}
 
+						if test.debugNets != nil {
+							nets := make([]string, len(mwe.conf.debugNets))
+							for i, n := range mwe.conf.debugNets {
+								nets[i] = n.String()
+							}
+							if strings.Join(nets, ",") != strings.Join(test.debugNets, ",") {
+								t.Errorf("Expected debug networks %v but got %v", test.debugNets, nets)
+							}
+						}
+
 						if test.streams != nil && *test.streams != mwe.conf.streams {
 							t.Errorf("Expected %d streams but got %d", *test.streams, mwe.conf.streams)
 						}
@@ -0,0 +1,113 @@
+package themis
+
+import (
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/infobloxopen/themis/pdp"
+	"github.com/miekg/dns"
+)
+
+// defaultDebugNets are the client networks allowed to send debug queries if none is configured
+var defaultDebugNets = []string{"127.0.0.0/8", "::1/128"}
+
+// maxTxtLen is the maximum length of a character string of a TXT record
+const maxTxtLen = 255
+
+// debugInfo holds the question of a debug query, and the PDP request and response of the queried domain
+type debugInfo struct {
+	question dns.Question
+	txt      [][]string
+}
+
+// debugQuery return the name of the domain queried by a debug query: a TXT query for a name that ends with the
+// debug suffix, from an allowed client
+func (p *ThemisEngine) debugQuery(state request.Request) (string, bool) {
+	suffix := p.conf.debugSuffix
+	if suffix == "" || state.QType() != dns.TypeTXT {
+		return "", false
+	}
+	name := state.Name()
+	if len(name) <= len(suffix) || !strings.HasSuffix(strings.ToLower(name), suffix) {
+		return "", false
+	}
+	if !p.debugAllowed(net.ParseIP(state.IP())) {
+		return "", false
+	}
+	return dns.Fqdn(name[:len(name)-len(suffix)]), true
+}
+
+// debugAllowed return true if the client is in one of the networks allowed to send debug queries
+func (p *ThemisEngine) debugAllowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.conf.debugNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// debugState return the request for the domain of a debug query, evaluated as a query of type A
+func debugState(state request.Request, name string) request.Request {
+	r := state.Req.Copy()
+	r.Question[0] = dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	return request.Request{W: state.W, Req: r}
+}
+
+// record keeps the PDP request and response, before the obligations are consumed by the attrHolder
+func (d *debugInfo) record(debugID string, req []pdp.AttributeAssignment, res *pdp.Response) {
+	d.txt = d.txt[:0]
+	if debugID != "" {
+		d.txt = append(d.txt, []string{"debug_id", debugID})
+	}
+	d.txt = append(d.txt, attrStrings("request", req))
+	effect := []string{"effect", pdp.EffectNameFromEnum(res.Effect)}
+	if res.Status != nil {
+		effect = append(effect, res.Status.Error())
+	}
+	d.txt = append(d.txt, effect, attrStrings("obligations", res.Obligations))
+}
+
+// decision return the answer to the debug query: a TXT record for each of the debug_id, the request, the effect
+// and the obligations, followed by the action applied to the domain
+func (d *debugInfo) decision(action int) *policy.Decision {
+	txt := append(d.txt, []string{"action", policy.NameTypes[action]})
+	answer := make([]dns.RR, len(txt))
+	for i, s := range txt {
+		answer[i] = &dns.TXT{
+			Hdr: dns.RR_Header{Name: d.question.Name, Rrtype: dns.TypeTXT, Class: d.question.Qclass},
+			Txt: txtStrings(s),
+		}
+	}
+	rcode := dns.RcodeSuccess
+	return &policy.Decision{Action: policy.TypeBlock, Rcode: &rcode, Answer: answer}
+}
+
+// attrStrings return the key followed by each attribute, as (type)name:value
+func attrStrings(key string, attrs []pdp.AttributeAssignment) []string {
+	s := make([]string, 0, len(attrs)+1)
+	s = append(s, key)
+	for _, a := range attrs {
+		s = append(s, a.String())
+	}
+	return s
+}
+
+var txtEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// txtStrings truncates and escapes the character strings of a TXT record
+func txtStrings(s []string) []string {
+	out := make([]string, len(s))
+	for i, v := range s {
+		if len(v) > maxTxtLen {
+			v = v[:maxTxtLen]
+		}
+		out[i] = txtEscaper.Replace(v)
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package themis
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/miekg/dns"
+)
+
+func TestDebugQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "themis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policyFile := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(policyFile, []byte(testPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newThemisEngine()
+	p.conf.policyFile = policyFile
+	p.conf.debugSuffix = "debug."
+	p.conf.debugID = "instance_1"
+	for _, s := range defaultDebugNets {
+		_, n, _ := net.ParseCIDR(s)
+		p.conf.debugNets = append(p.conf.debugNets, n)
+	}
+	if err := p.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.closeConn()
+
+	tests := []struct {
+		desc     string
+		name     string
+		qtype    uint16
+		client   string
+		expected [][]string
+	}{
+		{
+			desc:   "DebugQuery",
+			name:   "example.com.debug.",
+			qtype:  dns.TypeTXT,
+			client: "127.0.0.1",
+			expected: [][]string{
+				{"debug_id", "instance_1"},
+				{"request", "(string)type:query", "(domain)domain_name:example.com.", "(string)dns_qtype:1",
+					"(address)source_ip:127.0.0.1"},
+				{"effect", "Permit"},
+				{"obligations", "(string)rule:Query rule for example.com"},
+				{"action", "allow"},
+			},
+		},
+		{
+			desc:   "NotAllowedClient",
+			name:   "example.com.debug.",
+			qtype:  dns.TypeTXT,
+			client: "192.0.2.1",
+		},
+		{
+			desc:   "NotTXT",
+			name:   "example.com.debug.",
+			qtype:  dns.TypeA,
+			client: "127.0.0.1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			state := buildState(test.name, test.qtype, test.client)
+			data, err := p.BuildQueryData(context.TODO(), state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := p.Decide(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.expected == nil {
+				if len(d.Answer) > 0 || d.Action != policy.TypeNone {
+					t.Errorf("expected no debug answer, got action %s and answer %v", policy.NameTypes[d.Action], d.Answer)
+				}
+				return
+			}
+			if d.Action != policy.TypeBlock || d.Rcode == nil || *d.Rcode != dns.RcodeSuccess {
+				t.Errorf("expected a NOERROR reply, got action %s", policy.NameTypes[d.Action])
+			}
+			if len(d.Answer) != len(test.expected) {
+				t.Fatalf("expected %d TXT records, got %v", len(test.expected), d.Answer)
+			}
+			for i, rr := range d.Answer {
+				txt, ok := rr.(*dns.TXT)
+				if !ok || txt.Hdr.Name != test.name {
+					t.Errorf("expected TXT record for %s, got %s", test.name, rr)
+					continue
+				}
+				if len(txt.Txt) != len(test.expected[i]) {
+					t.Errorf("expected TXT %q, got %q", test.expected[i], txt.Txt)
+					continue
+				}
+				for j, s := range txt.Txt {
+					if s != test.expected[i][j] {
+						t.Errorf("expected TXT %q, got %q", test.expected[i], txt.Txt)
+						break
+					}
+				}
+			}
+		})
+	}
+}
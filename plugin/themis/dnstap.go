@@ -0,0 +1,52 @@
+package themis
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/coredns/coredns/plugin/metadata"
+)
+
+// dnstapMetadata return the metadata label of the dnstap attributes of the PDP responses of the engine
+func dnstapMetadata(engine string) string {
+	return "themis/dnstap_" + engine
+}
+
+// hasDnstap return true if any attribute of the PDP response is configured for dnstap
+func (conf *config) hasDnstap() bool {
+	for _, a := range conf.custAttrs {
+		if a.isDnstap() {
+			return true
+		}
+	}
+	return false
+}
+
+// setDnstapMetadata exposes the dnstap attributes of the request as metadata. The value is computed when it is
+// read, e.g. by the dnstap plugin when it writes the response, after the PDP validated the request
+func setDnstapMetadata(ctx context.Context, engine string, ah *attrHolder) {
+	metadata.SetValueFunc(ctx, dnstapMetadata(engine), ah.dnstapExtra)
+}
+
+// dnstapExtra return the dnstap attributes as a JSON object of the attribute names and values,
+// e.g. {"policy_id":"P1","category":"malware"}, or an empty string if there is none
+func (ah *attrHolder) dnstapExtra() string {
+	if len(ah.dnstap) == 0 {
+		return ""
+	}
+	m := make(map[string]string, len(ah.dnstap))
+	for _, a := range ah.dnstap {
+		id, _, v, err := a.Serialize(emptyCtx)
+		if err != nil {
+			log.Printf("[ERROR] Can't serialize dnstap attribute %q: %s", id, err)
+			continue
+		}
+		m[id] = v
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,42 @@
+package themis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/pkg/replacer"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/infobloxopen/themis/pdp"
+	"github.com/miekg/dns"
+)
+
+func TestDnstapExtra(t *testing.T) {
+	p := newThemisEngine()
+	p.name = "NAME"
+	p.dnstap = true
+	p.conf.custAttrs["policy_id"] = custAttrDnstap
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	state := request.Request{W: &test.ResponseWriter{}, Req: r}
+	ctx := metadata.ContextWithMetadata(context.TODO())
+
+	data, err := p.BuildQueryData(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the extra field of the dnstap plugin is formatted when the response is written, after the validation
+	extra := "{/themis/dnstap_NAME}"
+	if v := replacer.New().Replace(ctx, state, nil, extra); v != "" {
+		t.Errorf("expected an empty dnstap extra before the validation but got %q", v)
+	}
+	data.(*attrHolder).addDnRes(&pdp.Response{
+		Effect:      pdp.EffectPermit,
+		Obligations: []pdp.AttributeAssignment{pdp.MakeStringAssignment("policy_id", "P1")},
+	}, p.conf.custAttrs)
+	if v := replacer.New().Replace(ctx, state, nil, extra); v != `{"policy_id":"P1"}` {
+		t.Errorf("expected dnstap extra %q but got %q", `{"policy_id":"P1"}`, v)
+	}
+}
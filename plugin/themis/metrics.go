@@ -233,9 +233,36 @@ func unixTime() uint32 {
 	return uint32(time.Now().Unix())
 }
 
+// policyReloads counts the reloads of the policy and content files of the builtin PDP client
+var policyReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "themis",
+	Name:      "policy_reloads_total",
+	Help:      "Counter of reloads of the policy and content files of the builtin PDP, by status (success or failure).",
+}, []string{"engine", "status"})
+
+// contentUpdates counts the content updates applied by the builtin PDP client
+var contentUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "themis",
+	Name:      "content_updates_total",
+	Help:      "Counter of content updates applied to the builtin PDP, by status (success or failure).",
+}, []string{"engine", "status"})
+
+var reloadMetricsOnce sync.Once
+
 // SetupMetrics checks for configured metrics attributes and starts and
 // configures globalAttrGauge as needed
 func (pp *ThemisEngine) SetupMetrics(c *caddy.Controller) error {
+	if pp.conf.policyFile != "" {
+		if m, ok := dnsserver.GetConfig(c).Handler("prometheus").(*metrics.Metrics); ok {
+			reloadMetricsOnce.Do(func() {
+				m.MustRegister(policyReloads)
+				m.MustRegister(contentUpdates)
+			})
+		}
+	}
+
 	attrNames := []string{}
 	for attr, t := range pp.conf.custAttrs {
 		if !t.isMetrics() {
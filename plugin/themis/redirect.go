@@ -0,0 +1,61 @@
+package themis
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/infobloxopen/themis/pdp"
+	"github.com/miekg/dns"
+)
+
+// redirectTTL is the TTL of the records synthesized for a redirect
+const redirectTTL = 60
+
+// addRedirect sets the destination of the redirect_to obligation: an IP address or a domain name.
+// An invalid destination is logged and ignored, the domain is then blocked
+func (ah *attrHolder) addRedirect(o pdp.AttributeAssignment) {
+	id, t, v, err := o.Serialize(emptyCtx)
+	if err != nil {
+		log.Printf("[ERROR] Can't get %q obligation: %s", id, err)
+		return
+	}
+	switch t {
+	case pdp.TypeString.GetKey(), pdp.TypeDomain.GetKey(), pdp.TypeAddress.GetKey():
+	default:
+		log.Printf("[ERROR] Invalid %q obligation of type %s", id, t)
+		return
+	}
+	if net.ParseIP(v) == nil {
+		if _, ok := dns.IsDomainName(v); !ok || v == "" || strings.ContainsAny(v, " \t\"\\") {
+			log.Printf("[ERROR] Invalid %q obligation %q: neither an IP address nor a domain name", id, v)
+			return
+		}
+	}
+	ah.dst = v
+}
+
+// redirect return the decision of a redirect to the destination: an A or AAAA record for the queried name if
+// the destination is an IP address of the type of the query, or a CNAME record if it is a domain name.
+// There is no answer for an IP address of another type
+func (ah *attrHolder) redirect() *policy.Decision {
+	hdr := dns.RR_Header{Name: ah.dn, Class: dns.ClassINET, Ttl: redirectTTL}
+	var answer []dns.RR
+	if ip := net.ParseIP(ah.dst); ip != nil {
+		ip4 := ip.To4()
+		switch {
+		case ip4 != nil && ah.qtype == dns.TypeA:
+			hdr.Rrtype = dns.TypeA
+			answer = append(answer, &dns.A{Hdr: hdr, A: ip4})
+		case ip4 == nil && ah.qtype == dns.TypeAAAA:
+			hdr.Rrtype = dns.TypeAAAA
+			answer = append(answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	} else {
+		hdr.Rrtype = dns.TypeCNAME
+		answer = append(answer, &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(ah.dst)})
+	}
+	rcode := dns.RcodeSuccess
+	return &policy.Decision{Action: policy.TypeBlock, Rcode: &rcode, Answer: answer}
+}
@@ -0,0 +1,39 @@
+package themis
+
+import (
+	"testing"
+
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/miekg/dns"
+)
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		qtype    uint16
+		dst      string
+		expected []string
+	}{
+		{dns.TypeA, "192.0.2.1", []string{"example.com.\t60\tIN\tA\t192.0.2.1"}},
+		{dns.TypeAAAA, "2001:db8::1", []string{"example.com.\t60\tIN\tAAAA\t2001:db8::1"}},
+		{dns.TypeAAAA, "192.0.2.1", nil},
+		{dns.TypeA, "2001:db8::1", nil},
+		{dns.TypeA, "redirect.example.net", []string{"example.com.\t60\tIN\tCNAME\tredirect.example.net."}},
+		{dns.TypeMX, "redirect.example.net.", []string{"example.com.\t60\tIN\tCNAME\tredirect.example.net."}},
+	}
+	for i, test := range tests {
+		ah := &attrHolder{dn: "example.com.", qtype: test.qtype, action: policy.TypeBlock, dst: test.dst}
+		d := ah.redirect()
+		if d.Action != policy.TypeBlock || d.Rcode == nil || *d.Rcode != dns.RcodeSuccess {
+			t.Errorf("TC #%d: expected a NOERROR reply, got action %s", i, policy.NameTypes[d.Action])
+		}
+		if len(d.Answer) != len(test.expected) {
+			t.Errorf("TC #%d: expected answer %v, got %v", i, test.expected, d.Answer)
+			continue
+		}
+		for j, rr := range d.Answer {
+			if rr.String() != test.expected[j] {
+				t.Errorf("TC #%d: expected record %q, got %q", i, test.expected[j], rr.String())
+			}
+		}
+	}
+}
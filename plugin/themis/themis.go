@@ -24,6 +24,7 @@ const ThemisPluginName = "themis"
 // requests and replies using PDP server.
 
 type ThemisEngine struct {
+	name            string
 	conf            config
 	trace           plugin.Handler
 	next            plugin.Handler
@@ -33,6 +34,7 @@ type ThemisEngine struct {
 	connAttempts    map[string]*uint32
 	unkConnAttempts *uint32
 	mapping         *rqdata.Mapping
+	dnstap          bool // true if attributes of the PDP response are configured for dnstap
 	wg              sync.WaitGroup
 }
 
@@ -52,7 +54,16 @@ func newThemisEngine() *ThemisEngine {
 }
 
 func (p *ThemisEngine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
+	if name, ok := p.debugQuery(state); ok {
+		q := state.Req.Question[0]
+		ah := newAttrHolderWithContext(ctx, rqdata.NewExtractor(debugState(state, name), p.mapping), p.conf.options, p.attrGauges)
+		ah.debug = &debugInfo{question: q}
+		return ah, nil
+	}
 	ah := newAttrHolderWithContext(ctx, rqdata.NewExtractor(state, p.mapping), p.conf.options, p.attrGauges)
+	if p.dnstap {
+		setDnstapMetadata(ctx, p.name, ah)
+	}
 	return ah, nil
 }
 
@@ -67,6 +78,16 @@ func (p *ThemisEngine) BuildRule(args []string) (policy.Rule, error) {
 }
 
 func (p *ThemisEngine) Evaluate(data interface{}) (int, error) {
+	d, err := p.Decide(data)
+	if err != nil {
+		return dns.RcodeSuccess, err
+	}
+	return d.Action, nil
+}
+
+// Decide implements the policy.Decider interface. A debug query is answered with the PDP request and response,
+// and a redirect with the records of its destination
+func (p *ThemisEngine) Decide(data interface{}) (*policy.Decision, error) {
 	ah := data.(*attrHolder)
 	var attrsRequest []pdp.AttributeAssignment
 	if !p.conf.autoResAttrs {
@@ -75,9 +96,15 @@ func (p *ThemisEngine) Evaluate(data interface{}) (int, error) {
 	}
 	// validate domain name (validation #1)
 	if err := p.validate(ah, attrsRequest); err != nil {
-		return dns.RcodeSuccess, err
+		return nil, err
+	}
+	if ah.debug != nil {
+		return ah.debug.decision(int(ah.action)), nil
+	}
+	if ah.action == policy.TypeBlock && ah.dst != "" {
+		return ah.redirect(), nil
 	}
-	return int(ah.action), nil
+	return &policy.Decision{Action: int(ah.action)}, nil
 }
 
 type ThemisPlugin struct {
@@ -0,0 +1,114 @@
+package wasm
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+	"github.com/coredns/policy/plugin/pkg/watch"
+)
+
+func init() {
+	caddy.RegisterPlugin("wasm", caddy.Plugin{
+		ServerType: "dns",
+		Action:     setup,
+	})
+}
+
+func setup(c *caddy.Controller) error {
+	p, err := parse(c)
+	if err != nil {
+		return plugin.Error("wasm", err)
+	}
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		p.next = next
+		return p
+	})
+	for _, e := range p.engines {
+		e := e
+		c.OnStartup(func() error {
+			e.start()
+			return nil
+		})
+		c.OnShutdown(func() error {
+			e.stop()
+			return nil
+		})
+	}
+	return nil
+}
+
+func parse(c *caddy.Controller) (*wasm, error) {
+	p := newWasm()
+	mapping := rqdata.NewMapping("")
+	for c.Next() {
+		args := c.RemainingArgs()
+		if len(args) != 1 {
+			return nil, c.ArgErr()
+		}
+		name := args[0]
+		if _, ok := p.engines[name]; ok {
+			return nil, c.Errf("wasm engine %s is already declared", name)
+		}
+		e := newEngine(mapping)
+		for c.NextBlock() {
+			switch c.Val() {
+			case "module":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				e.path = args[0]
+			case "fields":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				// these fields cannot be validated, because metadata fields are not known at setup time
+				e.fields = args
+			case "instances":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n < 0 {
+					return nil, c.Errf("invalid number of instances '%s'", args[0])
+				}
+				e.instances = n
+			case "timeout":
+				args := c.RemainingArgs()
+				if len(args) != 1 {
+					return nil, c.ArgErr()
+				}
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d <= 0 {
+					return nil, c.Errf("invalid timeout '%s'", args[0])
+				}
+				e.timeout = d
+			default:
+				return nil, c.Errf("unknown property '%s'", c.Val())
+			}
+		}
+		if e.path == "" {
+			return nil, c.Err("module required")
+		}
+		r, err := newRuntime()
+		if err != nil {
+			return nil, err
+		}
+		e.runtime = r
+		// a module file replaced while it is compiled is seen as changed, and compiled again
+		e.files = watch.New(ReloadInterval, e.path)
+		if err := e.load(); err != nil {
+			r.Close(context.Background())
+			return nil, c.Err(err.Error())
+		}
+		p.engines[name] = e
+	}
+	return p, nil
+}
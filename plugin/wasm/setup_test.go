@@ -0,0 +1,115 @@
+package wasm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.wasm")
+	writeModule(t, valid, constantModule(0))
+	invalid := filepath.Join(dir, "invalid.wasm")
+	writeModule(t, invalid, []byte("not a module"))
+	// a module without the exports of the ABI
+	empty := filepath.Join(dir, "empty.wasm")
+	writeModule(t, empty, []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00})
+	// a module where evaluate returns no action
+	noResult := filepath.Join(dir, "noresult.wasm")
+	writeModule(t, noResult, signatureModule([]byte{0x00}))
+
+	tests := []struct {
+		input     string
+		shouldErr bool
+		engines   []string
+		instances int
+	}{
+		{`wasm`, true, nil, 0},
+		{`wasm myengine`, true, nil, 0},
+		{`wasm myengine {
+			module ` + valid + `
+		}`, false, []string{"myengine"}, 8},
+		{`wasm myengine {
+			module ` + valid + `
+			fields client_ip name
+			instances 2
+		}`, false, []string{"myengine"}, 2},
+		{`wasm myengine {
+			module ` + valid + `
+			instances -1
+		}`, true, nil, 0},
+		{`wasm myengine {
+			module ` + valid + `
+			unknown
+		}`, true, nil, 0},
+		{`wasm myengine {
+			module ` + invalid + `
+		}`, true, nil, 0},
+		{`wasm myengine {
+			module ` + empty + `
+		}`, true, nil, 0},
+		{`wasm myengine {
+			module ` + noResult + `
+		}`, true, nil, 0},
+		{`wasm myengine {
+			module ` + valid + `
+			timeout 10ms
+		}`, false, []string{"myengine"}, 8},
+		{`wasm myengine {
+			module ` + valid + `
+			timeout 0s
+		}`, true, nil, 0},
+		{`wasm myengine {
+			module ` + filepath.Join(dir, "missing.wasm") + `
+		}`, true, nil, 0},
+		{`wasm myengine {
+			module ` + valid + `
+		}
+		wasm other {
+			module ` + valid + `
+		}`, false, []string{"myengine", "other"}, 8},
+		{`wasm myengine {
+			module ` + valid + `
+		}
+		wasm myengine {
+			module ` + valid + `
+		}`, true, nil, 0},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		p, err := parse(c)
+		if test.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected error but didn't get one for input %s", i, test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: expected no error but got one for input %s, got: %v", i, test.input, err)
+			continue
+		}
+		if len(p.engines) != len(test.engines) {
+			t.Errorf("Test %d: expected %d engines, got %d", i, len(test.engines), len(p.engines))
+		}
+		for _, name := range test.engines {
+			e := p.engines[name]
+			if e == nil {
+				t.Errorf("Test %d: expected engine %s to be declared", i, name)
+				continue
+			}
+			if e.instances != test.instances {
+				t.Errorf("Test %d: expected %d instances, got %d", i, test.instances, e.instances)
+			}
+			e.stop()
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package wasm implements a policy engine for the firewall plugin where the policy is a WebAssembly module,
+// evaluated in-process by a pure Go runtime.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+	"github.com/coredns/policy/plugin/pkg/watch"
+
+	"github.com/miekg/dns"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// ReloadInterval is the period used to check the module files for changes
+var ReloadInterval = 5 * time.Second
+
+// wasm is a policy engine plugin for the firewall plugin that evaluates WebAssembly modules
+type wasm struct {
+	engines map[string]*engine
+	next    plugin.Handler
+}
+
+// engine evaluates the DNS requests and replies with a WebAssembly module.
+// The module is reloaded whenever the file changes on disk.
+type engine struct {
+	path      string
+	fields    []string        // fields to send as input to the module
+	mapping   *rqdata.Mapping // store this so we dont have to rebuild it for every request
+	instances int             // maximum number of idle instances kept for reuse
+	timeout   time.Duration   // deadline of each evaluation
+	runtime   wazero.Runtime
+	files     *watch.Watcher
+
+	sync.RWMutex
+	current *module
+}
+
+// module is one load of the WebAssembly module file, and the pool of its instances
+type module struct {
+	compiled  wazero.CompiledModule
+	instances int
+
+	sync.Mutex
+	idle   []*instance
+	busy   int
+	closed bool
+}
+
+// instance is an instance of the module, that can evaluate one input at a time
+type instance struct {
+	mod      api.Module
+	alloc    api.Function
+	dealloc  api.Function
+	evaluate api.Function
+}
+
+type input map[string]interface{}
+
+// export is the signature of a function of the ABI
+type export struct {
+	params   []api.ValueType
+	results  []api.ValueType
+	required bool
+}
+
+// abi are the functions the module exports to evaluate the inputs
+var abi = map[string]export{
+	"alloc":       {params: []api.ValueType{api.ValueTypeI32}, results: []api.ValueType{api.ValueTypeI32}, required: true},
+	"evaluate":    {params: []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, results: []api.ValueType{api.ValueTypeI32}, required: true},
+	"dealloc":     {params: []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}},
+	"_initialize": {},
+}
+
+func newWasm() *wasm {
+	return &wasm{engines: make(map[string]*engine)}
+}
+
+func newEngine(m *rqdata.Mapping) *engine {
+	return &engine{
+		mapping:   m,
+		fields:    []string{"client_ip", "name", "rcode", "response_ip"},
+		instances: 8,
+		timeout:   100 * time.Millisecond,
+	}
+}
+
+// Name implements the Handler interface
+func (p *wasm) Name() string { return "wasm" }
+
+// ServeDNS implements the Handler interface
+func (p *wasm) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	// do nothing
+	return plugin.NextOrFailure(p.Name(), p.next, ctx, w, r)
+}
+
+// Engine implements the policy.Engineer interface
+func (p *wasm) Engine(name string) policy.Engine {
+	return p.engines[name]
+}
+
+// BuildQueryData implements the policy.Engine interface
+func (e *engine) BuildQueryData(ctx context.Context, state request.Request) (interface{}, error) {
+	return e.buildData(ctx, state, make(input)), nil
+}
+
+// BuildReplyData implements the policy.Engine interface
+func (e *engine) BuildReplyData(ctx context.Context, state request.Request, queryData interface{}) (interface{}, error) {
+	return e.buildData(ctx, state, queryData.(input)), nil
+}
+
+// BuildRule implements the policy.Engine interface
+func (e *engine) BuildRule(args []string) (policy.Rule, error) { return e, nil }
+
+// Evaluate implements the policy.Rule interface
+func (e *engine) Evaluate(data interface{}) (int, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return 0, err
+	}
+	// the runtime closes the instance that is still running when the deadline is reached
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	// no new instance of a replaced module can be taken once the read lock is released
+	e.RLock()
+	m := e.current
+	inst, err := m.get(ctx, e.runtime)
+	e.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	action, err := inst.call(ctx, b)
+	if err != nil {
+		// the state of the instance is unknown after a failure, do not reuse it
+		inst.mod.Close(context.Background())
+		m.release(ctx, nil)
+		if ctx.Err() == context.DeadlineExceeded {
+			return 0, fmt.Errorf("wasm evaluation timed out after %s", e.timeout)
+		}
+		return 0, err
+	}
+	m.release(ctx, inst)
+
+	if action >= policy.TypeCount {
+		return 0, fmt.Errorf("unknown action: %d", action)
+	}
+	return int(action), nil
+}
+
+// buildData fills the map of values for the module input
+func (e *engine) buildData(ctx context.Context, state request.Request, data input) input {
+	extractor := rqdata.NewExtractor(state, e.mapping)
+	for _, f := range e.fields {
+		if _, ok := data[f]; ok {
+			// skip if already defined
+			continue
+		}
+		if e.mapping.ValidField(f) {
+			// numbers, booleans, lists and IP addresses are marshaled with their JSON type
+			if tv, _ := extractor.TypedValue(f); tv != nil {
+				data[f] = tv
+			}
+			continue
+		}
+		if v := metadata.ValueFunc(ctx, f); v != nil {
+			if s := v(); s != "" {
+				data[f] = s
+			}
+		}
+	}
+	return data
+}
+
+// load compiles the module file, and replaces the previous module only if it succeeds
+func (e *engine) load() error {
+	ctx := context.Background()
+	b, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return fmt.Errorf("cannot read wasm module %s : %s", e.path, err)
+	}
+	compiled, err := e.runtime.CompileModule(ctx, b)
+	if err != nil {
+		return fmt.Errorf("cannot compile wasm module %s : %s", e.path, err)
+	}
+	if err := checkABI(compiled); err != nil {
+		compiled.Close(ctx)
+		return fmt.Errorf("invalid wasm module %s : %s", e.path, err)
+	}
+	m := &module{compiled: compiled, instances: e.instances}
+	// ensure the module implements the ABI before replacing the previous one
+	inst, err := m.get(ctx, e.runtime)
+	if err != nil {
+		m.close(ctx)
+		return err
+	}
+	m.release(ctx, inst)
+
+	e.Lock()
+	old := e.current
+	e.current = m
+	e.Unlock()
+	if old != nil {
+		old.close(ctx)
+	}
+	return nil
+}
+
+func (e *engine) reload() {
+	if err := e.load(); err != nil {
+		log.Printf("[ERROR] Keeping previous wasm module: %s", err)
+		return
+	}
+	log.Printf("[INFO] Reloaded wasm module %s", e.path)
+}
+
+// start watches the module file and reload it on change, until stop is called
+func (e *engine) start() { e.files.Start(e.reload) }
+
+func (e *engine) stop() {
+	e.files.Stop()
+	// closing the runtime closes all the modules and their instances
+	e.runtime.Close(context.Background())
+}
+
+// get return an idle instance of the module, or a new one if none is idle
+func (m *module) get(ctx context.Context, r wazero.Runtime) (*instance, error) {
+	m.Lock()
+	m.busy++
+	if n := len(m.idle); n > 0 {
+		inst := m.idle[n-1]
+		m.idle = m.idle[:n-1]
+		m.Unlock()
+		return inst, nil
+	}
+	m.Unlock()
+	inst, err := newInstance(ctx, r, m.compiled)
+	if err != nil {
+		m.release(ctx, nil)
+		return nil, err
+	}
+	return inst, nil
+}
+
+// release gives back an instance taken by get, which is kept for reuse if the pool is not full
+func (m *module) release(ctx context.Context, inst *instance) {
+	m.Lock()
+	defer m.Unlock()
+	m.busy--
+	if inst != nil {
+		if !m.closed && len(m.idle) < m.instances {
+			m.idle = append(m.idle, inst)
+		} else {
+			inst.mod.Close(ctx)
+		}
+	}
+	if m.closed && m.busy == 0 {
+		m.compiled.Close(ctx)
+	}
+}
+
+// close releases the idle instances, and the compiled module once all instances are released
+func (m *module) close(ctx context.Context) {
+	m.Lock()
+	defer m.Unlock()
+	m.closed = true
+	for _, inst := range m.idle {
+		inst.mod.Close(ctx)
+	}
+	m.idle = nil
+	if m.busy == 0 {
+		m.compiled.Close(ctx)
+	}
+}
+
+func newInstance(ctx context.Context, r wazero.Runtime, compiled wazero.CompiledModule) (*instance, error) {
+	// instances are anonymous, so the same module can be instantiated several times
+	mod, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot instantiate wasm module : %s", err)
+	}
+	// the exports are checked by checkABI when the module is loaded
+	return &instance{
+		mod:      mod,
+		alloc:    mod.ExportedFunction("alloc"),
+		dealloc:  mod.ExportedFunction("dealloc"),
+		evaluate: mod.ExportedFunction("evaluate"),
+	}, nil
+}
+
+// checkABI ensures the module exports its memory, and the functions of the ABI with their signature
+func checkABI(compiled wazero.CompiledModule) error {
+	if len(compiled.ExportedMemories()) == 0 {
+		return fmt.Errorf("wasm module must export memory")
+	}
+	functions := compiled.ExportedFunctions()
+	for name, f := range abi {
+		def, ok := functions[name]
+		if !ok {
+			if f.required {
+				return fmt.Errorf("wasm module must export %s", name)
+			}
+			continue
+		}
+		if !sameTypes(def.ParamTypes(), f.params) || !sameTypes(def.ResultTypes(), f.results) {
+			return fmt.Errorf("wasm function %s must be %s", name, signature(f))
+		}
+	}
+	return nil
+}
+
+func sameTypes(a, b []api.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// signature return the signature of the function, e.g. (i32, i32) -> i32
+func signature(f export) string {
+	names := func(types []api.ValueType) string {
+		l := make([]string, len(types))
+		for i, t := range types {
+			l[i] = api.ValueTypeName(t)
+		}
+		return strings.Join(l, ", ")
+	}
+	s := "(" + names(f.params) + ")"
+	if len(f.results) > 0 {
+		s += " -> " + names(f.results)
+	}
+	return s
+}
+
+// call copies the input in the memory of the instance, and return the action returned by evaluate
+func (inst *instance) call(ctx context.Context, b []byte) (uint32, error) {
+	res, err := inst.alloc.Call(ctx, uint64(len(b)))
+	if err != nil {
+		return 0, fmt.Errorf("wasm alloc failed : %s", err)
+	}
+	ptr := uint32(res[0])
+	if !inst.mod.Memory().Write(ptr, b) {
+		return 0, fmt.Errorf("wasm alloc returned an address out of memory : %d", ptr)
+	}
+	res, err = inst.evaluate.Call(ctx, uint64(ptr), uint64(len(b)))
+	if err != nil {
+		return 0, fmt.Errorf("wasm evaluate failed : %s", err)
+	}
+	if inst.dealloc != nil {
+		if _, err := inst.dealloc.Call(ctx, uint64(ptr), uint64(len(b))); err != nil {
+			return 0, fmt.Errorf("wasm dealloc failed : %s", err)
+		}
+	}
+	return uint32(res[0]), nil
+}
+
+func newRuntime() (wazero.Runtime, error) {
+	ctx := context.Background()
+	// the executions are stopped when the context of the call is done, see the timeout of the evaluations
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	// modules built with WASI toolchains import it. Nothing of the host (files, environment, ...) is exposed to them
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return r, nil
+}
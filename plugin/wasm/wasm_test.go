@@ -0,0 +1,245 @@
+package wasm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/metadata"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/coredns/policy/plugin/firewall/policy"
+	"github.com/coredns/policy/plugin/pkg/response"
+	"github.com/coredns/policy/plugin/pkg/rqdata"
+	"github.com/miekg/dns"
+)
+
+// section encodes a section of a WebAssembly module, all test sections are shorter than 128 bytes
+func section(id byte, content ...byte) []byte {
+	return append([]byte{id, byte(len(content))}, content...)
+}
+
+// testModule return a WebAssembly module implementing the ABI, where evaluate executes the given code
+// with the address and length of the input as parameters 0 and 1
+func testModule(code ...byte) []byte {
+	return signatureModule([]byte{0x01, 0x7f}, code...)
+}
+
+// signatureModule return a WebAssembly module where evaluate has the encoded results, and executes the code
+func signatureModule(results []byte, code ...byte) []byte {
+	b := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	// types: (i32) -> i32 for alloc, (i32, i32) -> results for evaluate
+	types := append([]byte{0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f}, results...)
+	b = append(b, section(1, types...)...)
+	b = append(b, section(3, 0x02, 0x00, 0x01)...)
+	// one page of memory
+	b = append(b, section(5, 0x01, 0x00, 0x01)...)
+	b = append(b, section(7,
+		0x03,
+		0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+		0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+		0x08, 'e', 'v', 'a', 'l', 'u', 'a', 't', 'e', 0x00, 0x01,
+	)...)
+	// alloc always return the address 1024
+	allocBody := []byte{0x00, 0x41, 0x80, 0x08, 0x0b}
+	evaluateBody := append(append([]byte{0x00}, code...), 0x0b)
+	codes := []byte{0x02, byte(len(allocBody))}
+	codes = append(codes, allocBody...)
+	codes = append(codes, byte(len(evaluateBody)))
+	codes = append(codes, evaluateBody...)
+	return append(b, section(10, codes...)...)
+}
+
+// lastDigitModule evaluates to the digit before the closing brace of the JSON input, e.g. 3 for {"action":3}
+var lastDigitModule = testModule(
+	0x20, 0x00, 0x20, 0x01, 0x6a, // ptr + len
+	0x41, 0x02, 0x6b, // - 2
+	0x2d, 0x00, 0x00, // i32.load8_u
+	0x41, 0x30, 0x6b, // - '0'
+)
+
+// constantModule evaluates always to the action
+func constantModule(action byte) []byte {
+	return testModule(0x41, action)
+}
+
+func writeModule(t *testing.T, path string, b []byte) {
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.wasm")
+	writeModule(t, path, lastDigitModule)
+
+	p, err := parse(caddy.NewTestController("dns", `wasm myengine {
+		module `+path+`
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := p.engines["myengine"]
+	defer e.stop()
+
+	tests := []struct {
+		data      input
+		expected  int
+		shouldErr bool
+	}{
+		{input{"action": policy.TypeAllow}, policy.TypeAllow, false},
+		{input{"action": policy.TypeBlock}, policy.TypeBlock, false},
+		{input{"action": policy.TypeNone}, policy.TypeNone, false},
+		{input{"action": 9}, 0, true},
+	}
+	for i, tc := range tests {
+		result, err := e.Evaluate(tc.data)
+		if tc.shouldErr {
+			if err == nil {
+				t.Errorf("Test %d: expected an error at evaluate", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error at evaluate : %s", i, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Test %d: expected %s, got %s", i, policy.NameTypes[tc.expected], policy.NameTypes[result])
+		}
+	}
+
+	// concurrent evaluations use distinct instances of the module
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(action int) {
+			defer wg.Done()
+			result, err := e.Evaluate(input{"action": action})
+			if err != nil || result != action {
+				t.Errorf("expected %s, got %s, error : %v", policy.NameTypes[action], policy.NameTypes[result], err)
+			}
+		}(i % policy.TypeCount)
+	}
+	wg.Wait()
+	if n := len(e.current.idle); n == 0 || n > e.instances {
+		t.Errorf("expected between 1 and %d idle instances, got %d", e.instances, n)
+	}
+}
+
+func TestEvaluateTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "loop.wasm")
+	// evaluate loops forever
+	writeModule(t, path, testModule(0x03, 0x40, 0x0c, 0x00, 0x0b, 0x41, 0x00))
+
+	p, err := parse(caddy.NewTestController("dns", `wasm myengine {
+		module `+path+`
+		timeout 50ms
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := p.engines["myengine"]
+	defer e.stop()
+
+	start := time.Now()
+	if _, err := e.Evaluate(make(input)); err == nil {
+		t.Error("expected an error for an evaluation that does not complete")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the evaluation to be stopped after the timeout, took %s", d)
+	}
+	// the instance is discarded, a new one is created for the next evaluation
+	if n := len(e.current.idle); n != 0 {
+		t.Errorf("expected no idle instance, got %d", n)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.wasm")
+	writeModule(t, path, constantModule(policy.TypeAllow))
+
+	p, err := parse(caddy.NewTestController("dns", `wasm myengine {
+		module `+path+`
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := p.engines["myengine"]
+	defer e.stop()
+
+	if result, err := e.Evaluate(input{}); err != nil || result != policy.TypeAllow {
+		t.Fatalf("expected allow before reload, got %s, error : %v", policy.NameTypes[result], err)
+	}
+
+	writeModule(t, path, constantModule(policy.TypeDrop))
+	if err := e.load(); err != nil {
+		t.Fatalf("unexpected error at reload : %s", err)
+	}
+	if result, err := e.Evaluate(input{}); err != nil || result != policy.TypeDrop {
+		t.Errorf("expected drop after reload, got %s, error : %v", policy.NameTypes[result], err)
+	}
+
+	// a broken module keeps the previous one active
+	writeModule(t, path, []byte("not a module"))
+	if err := e.load(); err == nil {
+		t.Errorf("expected an error at reload of an invalid module")
+	}
+	if result, err := e.Evaluate(input{}); err != nil || result != policy.TypeDrop {
+		t.Errorf("expected drop to be kept after failed reload, got %s, error : %v", policy.NameTypes[result], err)
+	}
+}
+
+func TestBuildData(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	state := request.Request{W: response.NewReader(&test.ResponseWriter{}), Req: r}
+
+	e := newEngine(rqdata.NewMapping(""))
+	e.fields = []string{"client_ip", "name", "size", "rcode", "test/group", "test/missing"}
+
+	ctx := metadata.ContextWithMetadata(context.TODO())
+	metadata.SetValueFunc(ctx, "test/group", func() string { return "admin" })
+
+	d, err := e.BuildQueryData(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := d.(input)
+	if len(data) != 4 {
+		t.Errorf("expected 4 fields, got %v", data)
+	}
+	if data["name"] != "example.org." || data["size"] != 29 || data["test/group"] != "admin" {
+		t.Errorf("unexpected query data %v", data)
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	d, err = e.BuildReplyData(ctx, request.Request{W: &response.Reader{Msg: m}, Req: r}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := d.(input); data["rcode"] != "NOERROR" {
+		t.Errorf("expected rcode NOERROR, got %v", data["rcode"])
+	}
+}